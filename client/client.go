@@ -2,83 +2,285 @@ package main
 
 import (
 	"GoProjectL0/common"
+	"GoProjectL0/config"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/nats-io/stan.go"
+	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 )
 
+// newOrderCache - создание кэша заказов выбранного типа: memory - в памяти процесса, redis - общий на сервере Redis
+func newOrderCache(cfg config.CacheConfig) (common.OrderCache, error) {
+	mode, err := common.ParseExpirationMode(cfg.ExpirationMode)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Backend {
+	case "memory":
+		policy, err := common.ParseEvictionPolicy(cfg.Policy)
+		if err != nil {
+			return nil, err
+		}
+		cache := common.NewMemoryOrderCache(common.CacheConfig{
+			DefaultExpiration: cfg.DefaultExpiration,
+			CleanupInterval:   cfg.CleanupInterval,
+			MaxEntries:        cfg.MaxEntries,
+			MaxBytes:          cfg.MaxBytes,
+			Policy:            policy,
+			Shards:            cfg.Shards,
+			ExpirationMode:    mode,
+			// Часто читаемые заказы перезагружаются из БД незадолго до истечения и не выпадают из кэша
+			RefreshAhead: cfg.RefreshAhead,
+			// Ответы с заказами готовятся один раз при записи в кэш, поэтому лимит объема учитывает и их
+			EncodeJSON: true,
+			EncodeGzip: true,
+		})
+		// Сообщаем об удалении заказов из кэша, чтобы было видно, хватает ли его размера
+		cache.OnEvicted(func(uid string, _ common.Order, reason common.EvictionReason) {
+			fmt.Println(time.Now(), uid, "removed from cache:", reason)
+		})
+		return cache, nil
+	case "redis":
+		return common.NewRedisOrderCache(common.RedisConfig{
+			Addr:              cfg.RedisAddr,
+			Password:          cfg.RedisPassword,
+			DB:                cfg.RedisDB,
+			Prefix:            cfg.RedisPrefix,
+			DefaultExpiration: cfg.DefaultExpiration,
+			ExpirationMode:    mode,
+			RefreshAhead:      cfg.RefreshAhead,
+		})
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+}
+
+// runMigrate - команда migrate: up (по умолчанию) - применить новые миграции схемы,
+// down N - откатить миграции новее версии N, version - показать текущую версию схемы
+func runMigrate(conn common.Connector, args []string) error {
+	ctx := context.Background()
+	pool, err := pgxpool.Connect(ctx, conn.GetPGSQL())
+	if err != nil {
+		return fmt.Errorf("Unable to connect to database: %w", err)
+	}
+	defer pool.Close()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch {
+	case cmd == "up" && len(args) <= 1:
+		applied, err := common.Migrate(ctx, pool)
+		if err != nil {
+			return err
+		}
+		fmt.Println(time.Now(), "migrations applied:", applied)
+	case cmd == "down" && len(args) == 2:
+		target, err := strconv.Atoi(args[1])
+		if err != nil || target < 0 {
+			return fmt.Errorf("bad target version %q", args[1])
+		}
+		reverted, err := common.MigrateDown(ctx, pool, target)
+		if err != nil {
+			return err
+		}
+		fmt.Println(time.Now(), "migrations reverted:", reverted)
+	case cmd == "version" && len(args) == 1:
+		version, err := common.MigrationVersion(ctx, pool)
+		if err != nil {
+			return err
+		}
+		fmt.Println(version)
+	default:
+		return errors.New("usage: client migrate [up | down N | version]")
+	}
+	return nil
+}
+
 func main() {
 	var err error
+	cfg, args, err := config.Load("client", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println(time.Now(), "Can't load config:", err)
+		os.Exit(2)
+	}
+
+	connector := common.Connector{Uname: cfg.DB.User, Pass: cfg.DB.Password, Host: cfg.DB.Host, Port: strconv.Itoa(cfg.DB.Port), DBname: cfg.DB.Name}
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(connector, args[1:]); err != nil {
+			fmt.Println(time.Now(), "Migration going wrong:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	fmt.Println(time.Now(), "Work is beginning.")
 
-	// Создаем новый экземпляр структуры All с подключением к базе данных и кэшем
-	ServStruck := common.NewAll(common.Connector{Uname: "postgres", Pass: "1234", Host: "localhost", Port: "5432", DBname: "mydb"}, 15*time.Minute, 3*time.Minute)
+	cache, err := newOrderCache(cfg.Cache)
+	if err != nil {
+		fmt.Println(time.Now(), "Can't create cache:", err)
+		os.Exit(1)
+	}
+
+	// Создаем новый экземпляр структуры All с подключением к базе данных и кэшем,
+	// в котором заказы можно искать по трек-номеру, покупателю, транзакции и товарам
+	ServStruck := common.NewAll(connector, common.NewIndexedOrderCache(cache))
+	ServStruck.DBTimeout = cfg.DB.Timeout
+	ServStruck.MessageTimeout = cfg.Ingest.MessageTimeout
 
 	// Получаем строку для подключения к базе данных
 	StringOfConnectionToDataBase := ServStruck.Connctr.GetPGSQL()
 
 	// Подключаемся к базе данных
-	ServStruck.Pool, err = pgxpool.Connect(context.TODO(), StringOfConnectionToDataBase)
+	connectCtx, cancelConnect := context.WithTimeout(context.Background(), cfg.DB.Timeout)
+	ServStruck.Pool, err = pgxpool.Connect(connectCtx, StringOfConnectionToDataBase)
+	cancelConnect()
 	if err != nil {
 		fmt.Println("Unable to connect to database:", err)
 		os.Exit(1)
 	}
 	fmt.Println(time.Now(), "Connected to Database. Success")
 
-	// Инициализируем кэш
-	err = ServStruck.UploadCache()
-	if err != nil {
-		fmt.Println(time.Now(), "caching data going wrong:", err)
-		os.Exit(1)
+	// Приводим схему БД к версии, которую ожидает клиент. Миграция больших таблиц может занять больше db.timeout,
+	// поэтому время ограничено отдельно, db.migrate_timeout, а по умолчанию не ограничено
+	if cfg.DB.Migrate {
+		migrateCtx, cancelMigrate := context.WithCancel(context.Background())
+		if cfg.DB.MigrateTimeout > 0 {
+			migrateCtx, cancelMigrate = context.WithTimeout(context.Background(), cfg.DB.MigrateTimeout)
+		}
+		_, err := common.Migrate(migrateCtx, ServStruck.Pool)
+		cancelMigrate()
+		if err != nil {
+			fmt.Println(time.Now(), "Migration going wrong:", err)
+			os.Exit(1)
+		}
+	}
+	ServStruck.Store = common.NewPgOrderStore(ServStruck.Pool)
+	// Заказы из сообщений пишутся в БД пачками, чтобы не тратить на каждый отдельную транзакцию
+	if cfg.Ingest.BatchSize > 0 {
+		ServStruck.Batcher = common.NewOrderBatcher(ServStruck.Store, common.BatchConfig{MaxOrders: cfg.Ingest.BatchSize, MaxDelay: cfg.Ingest.BatchDelay, Timeout: cfg.Ingest.MessageTimeout})
+	}
+
+	// Восстанавливаем кэш из снимка, сохраненного при предыдущем завершении, если кэш это умеет
+	if snap, ok := cache.(common.Snapshotter); ok && cfg.Cache.Snapshot != "" {
+		loaded, err := snap.LoadFile(cfg.Cache.Snapshot)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println(time.Now(), "loading cache snapshot going wrong:", err)
+		}
+		fmt.Println(time.Now(), "loaded from snapshot:", loaded)
 	}
-	fmt.Println(time.Now(), "caching data complete")
 
 	// Подключаемся к серверу сообщений
-	ServStruck.StreamConn, err = stan.Connect("test-cluster", "client-123", stan.NatsURL("0.0.0.0:4222"))
+	ServStruck.StreamConn, err = stan.Connect(cfg.NATS.Cluster, cfg.NATS.ClientID, stan.NatsURL(cfg.NATS.URL))
 	if err != nil {
 		fmt.Println("Can't connect to cluster", err)
 		os.Exit(1)
 	}
 	fmt.Println(time.Now(), "Connected to cluster. Success")
 
+	// Реплики с кэшем в памяти оповещают друг друга об изменении заказов, общему кэшу в Redis это не нужно
+	if cfg.Cache.Backend == "memory" {
+		ServStruck.Cch, err = common.NewInvalidatingOrderCache(ServStruck.Cch, common.NatsBroker{Conn: ServStruck.StreamConn.NatsConn()}, cfg.NATS.InvalidationSubject)
+		if err != nil {
+			fmt.Println(time.Now(), "Can't subscribe to cache invalidations:", err)
+			os.Exit(1)
+		}
+	}
+
 	// Подписываемся на канал в сервере сообщений
-	ServStruck.StreamSubs, err = ServStruck.StreamConn.Subscribe("foo", ServStruck.MessageHandler)
+	// Сообщения подтверждаются вручную после сохранения заказа, несохраненные сервер доставит повторно.
+	// Подписка долговременная, поэтому сообщения, пришедшие, пока клиент был остановлен, тоже будут доставлены
+	ServStruck.StreamSubs, err = ServStruck.StreamConn.Subscribe(cfg.NATS.Subject, ServStruck.MessageHandler,
+		stan.DurableName(cfg.NATS.DurableName), stan.SetManualAckMode(), stan.AckWait(cfg.NATS.AckWait))
 	if err != nil {
 		fmt.Println("Can't subscribe to chanel:", err)
 		os.Exit(1)
 	}
 	fmt.Println(time.Now(), "Subscribe is done. Succsess")
 
-	// Запускаем HTTP-сервер, который слушает на порту 3000 и обрабатывает запросы с помощью метода OrderHandler экземпляра All
+	// Запускаем HTTP-сервер, который слушает на адресе cfg.HTTP.Addr и обрабатывает запросы с помощью метода OrderHandler экземпляра All,
+	// а статистику кэша отдает по адресу /stats.
+	// Сервер работает в отдельной горутине, чтобы основная могла дождаться сигнала завершения
 	http.HandleFunc("/", ServStruck.OrderHandler)
-	fmt.Println(time.Now(), "Listening on port: 3000")
-	err = http.ListenAndServe(":3000", nil)
-	if err != nil {
-		fmt.Println(time.Now(), "\"http.ListenAndServe\" have some err to you", err)
-		os.Exit(1)
-	}
+	http.HandleFunc("/stats", ServStruck.StatsHandler)
+	http.HandleFunc("/warmup", ServStruck.WarmupHandler)
+	http.HandleFunc("/ingest", ServStruck.IngestHandler)
+	// Контексты запросов порождаются от контекста ServStruck, поэтому ServStruck.Close прерывает и их
+	server := &http.Server{Addr: cfg.HTTP.Addr, BaseContext: func(net.Listener) context.Context { return ServStruck.Context() }}
+	go func() {
+		fmt.Println(time.Now(), "Listening on:", cfg.HTTP.Addr)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Println(time.Now(), "\"http.ListenAndServe\" have some err to you", err)
+			os.Exit(1)
+		}
+	}()
 
-	// Ожидаем прерывание сигнала от операционной системы, чтобы корректно отписаться от канала и закрыть соединения с сервером сообщений и базой данных
+	// Дозагружаем в кэш из базы данных то, чего не было в снимке. Сервер уже отвечает:
+	// заказы, до которых прогрев еще не дошел, читаются из БД, а ход прогрева виден по адресу /warmup
+	warmupCtx, stopWarmup := context.WithCancel(ServStruck.Context())
+	warmupDone := make(chan struct{})
+	go func() {
+		defer close(warmupDone)
+		err := ServStruck.UploadCache(warmupCtx, common.WarmupConfig{PageSize: cfg.Warmup.PageSize, BatchSize: cfg.Warmup.BatchSize, Workers: cfg.Warmup.Workers})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Println(time.Now(), "caching data going wrong:", err)
+		}
+	}()
+
+	// Ожидаем прерывание сигнала от операционной системы, чтобы корректно закрыть подписку на канал и соединения с сервером сообщений и базой данных
 	signalChan := make(chan os.Signal, 1)
 	cleanupDone := make(chan bool)
 	signal.Notify(signalChan, os.Interrupt)
 	go func() {
 		for range signalChan {
-			fmt.Println(time.Now(), "Received an interrupt, unsubscribing and closing connection...")
-			err := ServStruck.StreamSubs.Unsubscribe()
+			fmt.Println(time.Now(), "Received an interrupt, closing subscription and connection...")
+			stopWarmup()
+			<-warmupDone
+			// Даем выполняющимся запросам завершиться, а не успевшие прерываем
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+			err := server.Shutdown(shutdownCtx)
+			cancelShutdown()
+			if err != nil {
+				fmt.Println(time.Now(), "HTTP server shutdown going wrong:", err)
+			}
+			ServStruck.Close()
+			// Дописываем накопленные заказы и подтверждаем их сообщения, пока подписка открыта.
+			// Сообщения, пришедшие после этого, не сохраняются и не подтверждаются (ErrBatcherClosed).
+			// Подписка долговременная и ниже закрывается, а не удаляется, поэтому сервер доставит их
+			// повторно после перезапуска клиента, а не в этом процессе
+			if ServStruck.Batcher != nil {
+				ServStruck.Batcher.Close()
+			}
+			// Close, а не Unsubscribe: Unsubscribe удалила бы долговременную подписку вместе с позицией в канале
+			err = ServStruck.StreamSubs.Close()
 			if err != nil {
-				fmt.Println(time.Now(), "trouble in unsubscribing:", err)
+				fmt.Println(time.Now(), "trouble in closing subscription:", err)
 			}
 			err = ServStruck.StreamConn.Close()
 			if err != nil {
 				fmt.Println(time.Now(), "Closing connection with stream server going wrong", err)
 			}
+			// Сохраняем снимок кэша, чтобы при следующем старте не читать все заказы из БД
+			if snap, ok := cache.(common.Snapshotter); ok && cfg.Cache.Snapshot != "" {
+				err = snap.SaveFile(cfg.Cache.Snapshot)
+				if err != nil {
+					fmt.Println(time.Now(), "saving cache snapshot going wrong:", err)
+				}
+			}
 			ServStruck.Pool.Close()
+			ServStruck.Cch.Close()
 			cleanupDone <- true
 		}
 	}()
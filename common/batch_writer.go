@@ -0,0 +1,146 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBatcherClosed - писатель пачек закрыт и заказы больше не принимает
+var ErrBatcherClosed = errors.New("Order batcher closed")
+
+// BatchConfig - параметры накопления заказов в пачки
+type BatchConfig struct {
+	// Максимальное количество заказов в пачке, по умолчанию 100
+	MaxOrders int
+	// Сколько пачка ждет новых заказов после первого, по умолчанию 20 мс
+	MaxDelay time.Duration
+	// Ограничение времени записи одной пачки, 0 - без ограничения
+	Timeout time.Duration
+}
+
+// batchRequest - заказ, ожидающий записи, и обработчик результата его записи
+type batchRequest struct {
+	order Order
+	done  func(err error)
+}
+
+// OrderBatcher - писатель, накапливающий заказы и сохраняющий их пачками одной транзакцией (OrderStore.SaveMany).
+// Пачка записывается, когда в ней MaxOrders заказов или с первого заказа прошло MaxDelay.
+// Пачки записываются по одной в отдельной горутине, там же вызываются обработчики результатов
+type OrderBatcher struct {
+	store OrderStore
+	cfg   BatchConfig
+
+	mu       sync.RWMutex
+	closed   bool
+	requests chan batchRequest
+	stopped  chan struct{}
+}
+
+// NewOrderBatcher - создание писателя пачек в хранилище store и запуск его горутины
+func NewOrderBatcher(store OrderStore, cfg BatchConfig) *OrderBatcher {
+	if cfg.MaxOrders <= 0 {
+		cfg.MaxOrders = 100
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 20 * time.Millisecond
+	}
+	b := &OrderBatcher{
+		store:    store,
+		cfg:      cfg,
+		requests: make(chan batchRequest, cfg.MaxOrders),
+		stopped:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Submit - постановка заказа в очередь на запись. done вызывается ровно один раз с результатом записи
+// (значения как у OrderStore.Save), после Close - сразу с ErrBatcherClosed
+func (b *OrderBatcher) Submit(o Order, done func(err error)) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		done(ErrBatcherClosed)
+		return
+	}
+	b.requests <- batchRequest{order: o, done: done}
+}
+
+// Save - запись заказа в составе пачки с ожиданием результата
+func (b *OrderBatcher) Save(ctx context.Context, o Order) error {
+	result := make(chan error, 1)
+	b.Submit(o, func(err error) { result <- err })
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		// Заказ все равно будет записан, но результат уже никому не нужен
+		return ctx.Err()
+	}
+}
+
+// Close - запись накопленных заказов и остановка писателя
+func (b *OrderBatcher) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.requests)
+	}
+	b.mu.Unlock()
+	<-b.stopped
+}
+
+// run - накопление заказов в пачки и их запись, пока не закрыт канал запросов
+func (b *OrderBatcher) run() {
+	defer close(b.stopped)
+
+	batch := make([]batchRequest, 0, b.cfg.MaxOrders)
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	for {
+		select {
+		case r, ok := <-b.requests:
+			if !ok {
+				if timer != nil {
+					timer.Stop()
+				}
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) == 1 {
+				timer = time.NewTimer(b.cfg.MaxDelay)
+				timeout = timer.C
+			}
+			if len(batch) < b.cfg.MaxOrders {
+				continue
+			}
+			timer.Stop()
+		case <-timeout:
+		}
+		timeout = nil
+		b.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush - запись пачки и передача результатов обработчикам
+func (b *OrderBatcher) flush(batch []batchRequest) {
+	if len(batch) == 0 {
+		return
+	}
+	orders := make([]Order, len(batch))
+	for i, r := range batch {
+		orders[i] = r.order
+	}
+	ctx, cancel := withTimeout(context.Background(), b.cfg.Timeout)
+	defer cancel()
+	errs := b.store.SaveMany(ctx, orders)
+	for i, r := range batch {
+		r.done(errs[i])
+	}
+}
@@ -0,0 +1,169 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingOrderStore - хранилище в памяти, запоминающее размеры записанных пачек
+type recordingOrderStore struct {
+	*MemoryOrderStore
+
+	mu      sync.Mutex
+	batches []int
+}
+
+func (s *recordingOrderStore) SaveMany(ctx context.Context, orders []Order) []error {
+	s.mu.Lock()
+	s.batches = append(s.batches, len(orders))
+	s.mu.Unlock()
+	return s.MemoryOrderStore.SaveMany(ctx, orders)
+}
+
+// batchSizes - размеры записанных пачек в порядке записи
+func (s *recordingOrderStore) batchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int(nil), s.batches...)
+}
+
+// waitResults - n результатов из results, не дольше 5 секунд на каждый
+func waitResults(t *testing.T, results <-chan error, n int) []error {
+	t.Helper()
+	errs := make([]error, 0, n)
+	for len(errs) < n {
+		select {
+		case err := <-results:
+			errs = append(errs, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d results, want %d", len(errs), n)
+		}
+	}
+	return errs
+}
+
+func TestOrderBatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    BatchConfig
+		orders int
+		// close - закрыть писатель сразу после постановки заказов
+		close       bool
+		wantBatches []int
+	}{
+		{name: "flush on size", cfg: BatchConfig{MaxOrders: 3, MaxDelay: time.Hour}, orders: 3, wantBatches: []int{3}},
+		{name: "flush on delay", cfg: BatchConfig{MaxOrders: 100, MaxDelay: 10 * time.Millisecond}, orders: 1, wantBatches: []int{1}},
+		{name: "close drains", cfg: BatchConfig{MaxOrders: 100, MaxDelay: time.Hour}, orders: 2, close: true, wantBatches: []int{2}},
+		{name: "size then close", cfg: BatchConfig{MaxOrders: 2, MaxDelay: time.Hour}, orders: 3, close: true, wantBatches: []int{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &recordingOrderStore{MemoryOrderStore: NewMemoryOrderStore()}
+			b := NewOrderBatcher(store, tt.cfg)
+			t.Cleanup(b.Close)
+
+			results := make(chan error, tt.orders)
+			orders := make([]Order, tt.orders)
+			for i := range orders {
+				orders[i] = *NewOrderGen()
+				b.Submit(orders[i], func(err error) { results <- err })
+			}
+			if tt.close {
+				b.Close()
+			}
+
+			for i, err := range waitResults(t, results, tt.orders) {
+				if err != nil {
+					t.Fatalf("result %d = %v, want nil", i, err)
+				}
+			}
+			batches := store.batchSizes()
+			if len(batches) != len(tt.wantBatches) {
+				t.Fatalf("batches = %v, want %v", batches, tt.wantBatches)
+			}
+			for i := range batches {
+				if batches[i] != tt.wantBatches[i] {
+					t.Fatalf("batches = %v, want %v", batches, tt.wantBatches)
+				}
+			}
+			for _, o := range orders {
+				if _, err := store.Get(context.Background(), o.OrderUID); err != nil {
+					t.Fatalf("Get %s: %v", o.OrderUID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderBatcherClosed(t *testing.T) {
+	b := NewOrderBatcher(NewMemoryOrderStore(), BatchConfig{})
+	b.Close()
+	// Повторное закрытие ничего не делает
+	b.Close()
+
+	called := 0
+	b.Submit(*NewOrderGen(), func(err error) {
+		called++
+		if !errors.Is(err, ErrBatcherClosed) {
+			t.Fatalf("Submit after Close = %v, want ErrBatcherClosed", err)
+		}
+	})
+	if called != 1 {
+		t.Fatalf("done called %d times, want 1", called)
+	}
+	if err := b.Save(context.Background(), *NewOrderGen()); !errors.Is(err, ErrBatcherClosed) {
+		t.Fatalf("Save after Close = %v, want ErrBatcherClosed", err)
+	}
+}
+
+// Сообщения, записанные одной пачкой, получают те же результаты, что и при HandleMessage по одному
+func TestSubmitMessage(t *testing.T) {
+	a := newTestAll(t)
+	a.Batcher = NewOrderBatcher(a.Store, BatchConfig{MaxOrders: 10, MaxDelay: time.Hour})
+	o := *NewOrderGen()
+	changed := o
+	changed.TrackNumber = "changed"
+	encode := func(o Order) []byte {
+		data, err := json.Marshal(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	messages := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "new", data: encode(o)},
+		{name: "duplicate", data: encode(o)},
+		{name: "conflict", data: encode(changed), wantErr: ErrBadMessage},
+		{name: "bad json", data: []byte("{"), wantErr: ErrBadMessage},
+	}
+	results := make([]chan error, len(messages))
+	for i, m := range messages {
+		result := make(chan error, 1)
+		results[i] = result
+		a.submitMessage(m.data, func(err error) { result <- err })
+	}
+	// Сообщение, которое не разбирается, получает результат сразу, остальные - после записи пачки
+	a.Batcher.Close()
+
+	for i, m := range messages {
+		if err := waitResults(t, results[i], 1)[0]; !errors.Is(err, m.wantErr) {
+			t.Fatalf("%s: result = %v, want %v", m.name, err, m.wantErr)
+		}
+	}
+	if stats, want := a.IngestStats(), (IngestStats{Saved: 1, Duplicates: 1, Conflicts: 1, Rejected: 1}); stats != want {
+		t.Fatalf("IngestStats = %+v, want %+v", stats, want)
+	}
+	if cached, found := a.Cch.Peek(o.OrderUID); !found || cached.TrackNumber != o.TrackNumber {
+		t.Fatalf("cached order = %q, %v; want %q, true", cached.TrackNumber, found, o.TrackNumber)
+	}
+}
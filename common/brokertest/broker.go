@@ -0,0 +1,120 @@
+// Package brokertest - брокер сообщений в памяти процесса с тем же поведением подписок, что у NATS.
+// Нужен, чтобы проверять согласование кэшей нескольких реплик (common.InvalidatingOrderCache)
+// локально без сервера NATS. Реализует интерфейс common.Broker
+package brokertest
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClosed - брокер уже закрыт
+var ErrClosed = errors.New("Broker is closed")
+
+// subscription - подписка со своей очередью, чтобы медленный подписчик не задерживал остальных
+type subscription struct {
+	handler func(data []byte)
+	queue   chan []byte
+	// pending - опубликованные, но еще не обработанные сообщения
+	pending sync.WaitGroup
+}
+
+// Broker - брокер сообщений в памяти процесса. Сообщения каждому подписчику доставляются
+// в порядке публикации в отдельной горутине, как в NATS
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[string]map[*subscription]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewBroker - создание брокера
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[string]map[*subscription]struct{})}
+}
+
+// Publish - рассылка копии data всем подписчикам subject
+func (b *Broker) Publish(subject string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	for s := range b.subs[subject] {
+		s.pending.Add(1)
+		s.queue <- append([]byte(nil), data...)
+	}
+	return nil
+}
+
+// Subscribe - подписка handler на сообщения subject
+func (b *Broker) Subscribe(subject string, handler func(data []byte)) (func() error, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	s := &subscription{handler: handler, queue: make(chan []byte, 1024)}
+	if b.subs[subject] == nil {
+		b.subs[subject] = make(map[*subscription]struct{})
+	}
+	b.subs[subject][s] = struct{}{}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for data := range s.queue {
+			s.handler(data)
+			s.pending.Done()
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() {
+			b.mu.Lock()
+			if _, ok := b.subs[subject][s]; ok {
+				delete(b.subs[subject], s)
+				close(s.queue)
+			}
+			b.mu.Unlock()
+		})
+		return nil
+	}, nil
+}
+
+// Flush - ожидание, пока все подписчики обработают уже опубликованные сообщения
+func (b *Broker) Flush() {
+	b.mu.Lock()
+	var pending []*subscription
+	for _, subs := range b.subs {
+		for s := range subs {
+			pending = append(pending, s)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, s := range pending {
+		s.pending.Wait()
+	}
+}
+
+// Close - отмена всех подписок и ожидание завершения их обработчиков
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, subs := range b.subs {
+			for s := range subs {
+				close(s.queue)
+			}
+		}
+		b.subs = nil
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
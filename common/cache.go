@@ -0,0 +1,578 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrKeyNotFound - ключ отсутствует в кэше
+	ErrKeyNotFound = errors.New("Key not found!")
+	// ErrKeyExists - ключ уже есть в кэше
+	ErrKeyExists = errors.New("Key already exists")
+	// ErrVersionMismatch - версия элемента изменилась с момента чтения
+	ErrVersionMismatch = errors.New("Version mismatch")
+	// ErrTooLarge - элемент больше лимита MaxBytes
+	ErrTooLarge = errors.New("Value exceeds cache size limit")
+)
+
+// CacheConfig - параметры кэша
+type CacheConfig struct {
+	// Время жизни элемента по умолчанию, 0 - бессрочно
+	DefaultExpiration time.Duration
+	// Интервал запуска сборщика мусора, 0 - сборщик не запускается
+	CleanupInterval time.Duration
+	// Максимальное количество элементов, 0 - без ограничения
+	MaxEntries int
+	// Приблизительный максимальный объем элементов в байтах, 0 - без ограничения
+	MaxBytes int64
+	// Политика вытеснения при достижении лимитов
+	Policy EvictionPolicy
+	// Количество независимо блокируемых сегментов, 0 или 1 - один сегмент.
+	// Лимиты MaxEntries и MaxBytes делятся между сегментами поровну
+	Shards int
+	// Способ отсчета времени жизни для записей без собственного правила
+	ExpirationMode ExpirationMode
+	// Опережающая перезагрузка для записей без собственного правила, см. ExpirationPolicy
+	RefreshAhead time.Duration
+	// Источник времени, nil - системные часы
+	Clock Clock
+	// Сериализовать ли значения в JSON при записи (см. GetEncoded), EncodeGzip - дополнительно сжимать gzip.
+	// Сериализованные данные учитываются в лимите MaxBytes
+	EncodeJSON bool
+	EncodeGzip bool
+}
+
+// EvictionStats - счетчики удаленных из кэша элементов
+type EvictionStats struct {
+	// Вытеснено при достижении MaxEntries или MaxBytes
+	Capacity uint64 `json:"capacity"`
+	// Удалено сборщиком мусора по истечении времени жизни
+	Expired uint64 `json:"expired"`
+	// Не помещено в кэш, так как элемент сам по себе больше MaxBytes
+	Rejected uint64 `json:"rejected"`
+}
+
+// Структура кэша. Элементы распределены по сегментам по хэшу ключа,
+// у каждого сегмента своя блокировка, поэтому операции с разными сегментами не мешают друг другу
+type Cache[K comparable, V any] struct {
+	defaultExpiration time.Duration
+	cleanupInterval   time.Duration
+	maxEntries        int
+	maxBytes          int64
+	policy            EvictionPolicy
+	shards            []*cacheShard[K, V]
+	hasher            keyHasher[K]
+	sizer             func(K, V) int64
+	clock             Clock
+	encodeJSON        bool
+	encodeGzip        bool
+	version           atomic.Uint64
+	defaultPolicy     ExpirationPolicy
+	refresher         func(K) (V, error)
+	refreshing        sync.Map
+	// Есть ли элементы со скользящим временем жизни, чтение которых требует блокировки на запись
+	hasSliding atomic.Bool
+
+	loads loadGroup[K, V]
+
+	hooks cacheHooks[K, V]
+
+	gcMu   sync.Mutex
+	gcStop chan struct{}
+	gcDone chan struct{}
+
+	evictedCapacity atomic.Uint64
+	evictedExpired  atomic.Uint64
+	rejected        atomic.Uint64
+}
+
+// Структура элемента кэша
+type CacheItem[V any] struct {
+	Value      V
+	Created    time.Time
+	Expiration int64
+	Version    uint64
+	policy     ExpirationPolicy
+	size       int64
+	encoded    *Encoded
+}
+
+// Инициализация кэша
+func NewCache[K comparable, V any](defaultExpiration, cleanupInterval time.Duration) *Cache[K, V] {
+	return NewCacheWithConfig[K, V](CacheConfig{DefaultExpiration: defaultExpiration, CleanupInterval: cleanupInterval})
+}
+
+// NewCacheWithConfig - инициализация кэша с лимитами и политикой вытеснения
+func NewCacheWithConfig[K comparable, V any](cfg CacheConfig) *Cache[K, V] {
+	n := cfg.Shards
+	if n < 1 {
+		n = 1
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock{}
+	}
+	shards := make([]*cacheShard[K, V], n)
+	for i := range shards {
+		shards[i] = newCacheShard[K, V](shardLimit(cfg.MaxEntries, n), shardLimit(cfg.MaxBytes, n), cfg.Policy)
+	}
+
+	cache := Cache[K, V]{
+		shards:            shards,
+		hasher:            newKeyHasher[K](),
+		defaultExpiration: cfg.DefaultExpiration,
+		cleanupInterval:   cfg.CleanupInterval,
+		maxEntries:        cfg.MaxEntries,
+		maxBytes:          cfg.MaxBytes,
+		policy:            cfg.Policy,
+		clock:             cfg.Clock,
+		encodeJSON:        cfg.EncodeJSON,
+		encodeGzip:        cfg.EncodeGzip,
+		defaultPolicy:     ExpirationPolicy{Mode: cfg.ExpirationMode, RefreshAhead: cfg.RefreshAhead},
+		sizer: func(key K, value V) int64 {
+			return ApproxSize(key) + ApproxSize(value)
+		},
+	}
+
+	// Если интервал очистки больше 0, запускаем очистку
+	if cfg.CleanupInterval > 0 {
+		cache.StartGC()
+	}
+
+	return &cache
+}
+
+// SetSizer - замена функции оценки размера элемента, используемой для лимита MaxBytes.
+// Должна вызываться до начала работы с кэшем
+func (c *Cache[K, V]) SetSizer(sizer func(K, V) int64) {
+	c.sizer = sizer
+}
+
+// shard - сегмент, в котором хранится ключ
+func (c *Cache[K, V]) shard(key K) *cacheShard[K, V] {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[c.hasher.hash(key)%uint64(len(c.shards))]
+}
+
+// Set - запись значения в кэш с перезаписью существующего, то же что Upsert
+func (c *Cache[K, V]) Set(key K, value V, duration time.Duration) {
+	c.Upsert(key, value, duration)
+}
+
+// Add - добавление значения, если ключа еще нет в кэше, иначе ErrKeyExists
+func (c *Cache[K, V]) Add(key K, value V, duration time.Duration) error {
+	_, err := c.write(key, value, duration, func(item CacheItem[V], found bool) error {
+		if found {
+			return ErrKeyExists
+		}
+		return nil
+	})
+	return err
+}
+
+// Replace - замена значения существующего ключа, если ключа нет - ErrKeyNotFound
+func (c *Cache[K, V]) Replace(key K, value V, duration time.Duration) error {
+	_, err := c.write(key, value, duration, func(item CacheItem[V], found bool) error {
+		if !found {
+			return ErrKeyNotFound
+		}
+		return nil
+	})
+	return err
+}
+
+// Upsert - добавление или замена значения, возвращает новую версию элемента
+func (c *Cache[K, V]) Upsert(key K, value V, duration time.Duration) uint64 {
+	version, _ := c.write(key, value, duration, nil)
+	return version
+}
+
+// CompareAndSwap - замена значения, только если текущая версия элемента равна version.
+// Возвращает новую версию, ErrKeyNotFound или ErrVersionMismatch
+func (c *Cache[K, V]) CompareAndSwap(key K, version uint64, value V, duration time.Duration) (uint64, error) {
+	return c.write(key, value, duration, func(item CacheItem[V], found bool) error {
+		if !found {
+			return ErrKeyNotFound
+		}
+		if item.Version != version {
+			return ErrVersionMismatch
+		}
+		return nil
+	})
+}
+
+// write - общая часть всех операций записи. check вызывается под блокировкой с текущим
+// элементом (устаревшие элементы считаются отсутствующими) и может запретить запись, вернув ошибку.
+// Обработчики событий вызываются уже после снятия блокировки
+func (c *Cache[K, V]) write(key K, value V, duration time.Duration, check func(item CacheItem[V], found bool) error) (uint64, error) {
+	p := c.resolve(c.policyFor(duration))
+	now := c.clock.Now()
+	return c.writeAt(key, value, now, expirationAt(now, p.TTL), p, check)
+}
+
+// writeAt - запись с заданными моментами создания created и истечения expiration и правилом истечения p
+func (c *Cache[K, V]) writeAt(key K, value V, created time.Time, expiration int64, p ExpirationPolicy, check func(item CacheItem[V], found bool) error) (uint64, error) {
+	if p.Mode == ExpireSliding && p.TTL > 0 {
+		c.hasSliding.Store(true)
+	}
+	// Значение сериализуется до блокировки сегмента
+	var encoded *Encoded
+	if c.encodeJSON {
+		var err error
+		if encoded, err = EncodeJSON(value, c.encodeGzip); err != nil {
+			return 0, fmt.Errorf("Encoding value failed: %v", err)
+		}
+	}
+	version, evicted, err := c.writeLocked(key, value, encoded, created, expiration, p, check)
+	c.notifyEvicted(evicted)
+	if err == nil {
+		c.notifyInserted(key, value)
+	}
+	return version, err
+}
+
+// writeLocked - запись под блокировкой сегмента, возвращает вытесненные при этом элементы
+func (c *Cache[K, V]) writeLocked(key K, value V, encoded *Encoded, created time.Time, expiration int64, p ExpirationPolicy, check func(item CacheItem[V], found bool) error) (version uint64, evicted []evictedItem[K, V], err error) {
+	var size int64
+	if c.maxBytes > 0 {
+		size = c.sizer(key, value) + encoded.size()
+	}
+
+	s := c.shard(key)
+
+	s.Lock()
+
+	defer s.Unlock()
+
+	item, found := s.items[key]
+	if found && item.expired(c.clock.Now().UnixNano()) {
+		found = false
+	}
+	if check != nil {
+		if err := check(item, found); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if s.maxBytes > 0 && size > s.maxBytes {
+		c.rejected.Add(1)
+		return 0, nil, ErrTooLarge
+	}
+
+	// Старое значение удаляется, чтобы не учитывать его при проверке лимитов.
+	// Если оно уже истекло, об этом сообщается так же, как при удалении сборщиком мусора
+	if old, ok := s.remove(key); ok && !found {
+		evicted = append(evicted, evictedItem[K, V]{key, old.Value, ReasonExpired})
+		c.evictedExpired.Add(1)
+	}
+
+	// Освобождаем место под новый элемент согласно политике вытеснения
+	for s.overflows(size) {
+		victim, ok := s.evictor.victim()
+		if !ok {
+			break
+		}
+		old, _ := s.remove(victim)
+		evicted = append(evicted, evictedItem[K, V]{victim, old.Value, ReasonCapacity})
+		c.evictedCapacity.Add(1)
+	}
+
+	version = c.version.Add(1)
+	s.insert(key, CacheItem[V]{
+		Value:      value,
+		Expiration: expiration,
+		Created:    created,
+		Version:    version,
+		policy:     p,
+		size:       size,
+		encoded:    encoded,
+	})
+	return version, evicted, nil
+}
+
+// Get - метод для получения данных из кэша
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	value, _, found := c.GetWithVersion(key)
+	return value, found
+}
+
+// GetWithVersion - получение значения вместе с его версией для последующего CompareAndSwap
+func (c *Cache[K, V]) GetWithVersion(key K) (V, uint64, bool) {
+	item, found := c.lookup(key, true)
+	return item.Value, item.Version, found
+}
+
+// GetEncoded - получение значения вместе с его JSON, сериализованным при записи.
+// Если кэш создан без EncodeJSON, сериализованного значения нет (nil)
+func (c *Cache[K, V]) GetEncoded(key K) (V, *Encoded, bool) {
+	item, found := c.lookup(key, true)
+	return item.Value, item.encoded, found
+}
+
+// Peek - получение значения без учета обращения в статистике, вытеснении и продлении времени жизни
+func (c *Cache[K, V]) Peek(key K) (V, bool) {
+	item, found := c.lookup(key, false)
+	return item.Value, found
+}
+
+// lookup - поиск элемента, count - учитывать ли обращение в статистике попаданий и промахов.
+// Если элемента нет, возвращается пустой элемент
+func (c *Cache[K, V]) lookup(key K, count bool) (CacheItem[V], bool) {
+	var zero CacheItem[V]
+
+	s := c.shard(key)
+
+	// Политикам LRU и LFU нужно учитывать обращения, а скользящее время жизни продлевается при чтении,
+	// поэтому им требуется блокировка на запись
+	if c.policy == PolicyTTL && !(count && c.hasSliding.Load()) {
+		s.RLock()
+		defer s.RUnlock()
+	} else {
+		s.Lock()
+		defer s.Unlock()
+	}
+
+	item, found := s.items[key]
+
+	// Ключ не найден
+	if !found {
+		if count {
+			s.misses.Add(1)
+		}
+		return zero, false
+	}
+
+	// Если в момент запроса кэш устарел возвращаем нулевое значение
+	now := c.clock.Now()
+	if item.expired(now.UnixNano()) {
+		if count {
+			s.misses.Add(1)
+			s.expiredOnRead.Add(1)
+		}
+		return zero, false
+	}
+
+	if !count {
+		return item, true
+	}
+
+	s.hits.Add(1)
+	s.evictor.access(key)
+
+	// Скользящее время жизни отсчитывается заново от момента чтения
+	if item.policy.Mode == ExpireSliding && item.policy.TTL > 0 {
+		item.Expiration = expirationAt(now, item.policy.TTL)
+		s.items[key] = item
+		s.expirations.set(key, item.Expiration)
+	}
+
+	// Элемент скоро истечет - перезагружаем его в фоне, пока отдаем текущее значение
+	if item.policy.needsRefresh(item.Expiration, now.UnixNano()) {
+		c.scheduleRefresh(key, item.policy)
+	}
+
+	return item, true
+
+}
+
+func (c *Cache[K, V]) Delete(key K) error {
+	s := c.shard(key)
+
+	s.Lock()
+
+	item, found := s.remove(key)
+
+	s.Unlock()
+
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	c.notifyEvicted([]evictedItem[K, V]{{key, item.Value, ReasonDeleted}})
+
+	return nil
+
+}
+
+// GetOrLoad - получение значения из кэша, а при промахе - загрузка через loader и сохранение
+// со временем жизни по умолчанию. Одновременные промахи по одному ключу вызывают loader один раз
+func (c *Cache[K, V]) GetOrLoad(key K, loader func(K) (V, error)) (V, error) {
+	if value, found := c.Get(key); found {
+		return value, nil
+	}
+
+	return c.load(key, loader)
+}
+
+// GetOrLoadEncoded - то же, что GetOrLoad, но вместе с JSON значения, см. GetEncoded.
+// Если загруженное значение не удалось сохранить в кэше, сериализованного значения нет (nil)
+func (c *Cache[K, V]) GetOrLoadEncoded(key K, loader func(K) (V, error)) (V, *Encoded, error) {
+	if value, encoded, found := c.GetEncoded(key); found {
+		return value, encoded, nil
+	}
+
+	value, err := c.load(key, loader)
+	if err != nil {
+		return value, nil, err
+	}
+	if item, found := c.lookup(key, false); found {
+		return item.Value, item.encoded, nil
+	}
+	return value, nil, nil
+}
+
+// load - загрузка значения при промахе, одновременные промахи по одному ключу вызывают loader один раз
+func (c *Cache[K, V]) load(key K, loader func(K) (V, error)) (V, error) {
+	return c.loads.do(key, func() (V, error) {
+		// Значение могло появиться, пока мы регистрировали загрузку
+		if item, found := c.lookup(key, false); found {
+			return item.Value, nil
+		}
+
+		value, err := loader(key)
+		if err != nil {
+			return value, err
+		}
+		c.Upsert(key, value, 0)
+		return value, nil
+	})
+}
+
+// Range - обход всех неустаревших элементов кэша, обход прекращается, если f вернула false.
+// f вызывается под блокировкой сегмента на чтение, поэтому изменять кэш внутри f нельзя
+func (c *Cache[K, V]) Range(f func(key K, value V) bool) {
+	now := c.clock.Now().UnixNano()
+	for _, s := range c.shards {
+		if !s.rangeItems(now, f) {
+			return
+		}
+	}
+}
+
+// rangeItems - обход неустаревших элементов сегмента, false - обход прерван
+func (s *cacheShard[K, V]) rangeItems(now int64, f func(key K, value V) bool) bool {
+	s.RLock()
+
+	defer s.RUnlock()
+
+	for k, i := range s.items {
+		if i.expired(now) {
+			continue
+		}
+		if !f(k, i.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Len - количество элементов в кэше, включая устаревшие, но еще не удаленные сборщиком мусора
+func (c *Cache[K, V]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.RLock()
+		n += len(s.items)
+		s.RUnlock()
+	}
+	return n
+}
+
+// MaxEntries - лимит количества элементов, 0 - без ограничения
+func (c *Cache[K, V]) MaxEntries() int {
+	return c.maxEntries
+}
+
+// Bytes - приблизительный объем элементов кэша в байтах. Считается только при заданном MaxBytes
+func (c *Cache[K, V]) Bytes() int64 {
+	var n int64
+	for _, s := range c.shards {
+		s.RLock()
+		n += s.bytes
+		s.RUnlock()
+	}
+	return n
+}
+
+// EvictionStats - счетчики вытесненных и удаленных по времени жизни элементов
+func (c *Cache[K, V]) EvictionStats() EvictionStats {
+	return EvictionStats{
+		Capacity: c.evictedCapacity.Load(),
+		Expired:  c.evictedExpired.Load(),
+		Rejected: c.rejected.Load(),
+	}
+}
+
+// Запуск сборщика мусора в горутине. Повторный запуск работающего сборщика ничего не делает
+func (c *Cache[K, V]) StartGC() {
+	c.gcMu.Lock()
+	defer c.gcMu.Unlock()
+
+	if c.gcStop != nil || c.cleanupInterval <= 0 {
+		return
+	}
+	c.gcStop = make(chan struct{})
+	c.gcDone = make(chan struct{})
+	// Тикер создается до запуска горутины, чтобы отсчет интервала шел от вызова StartGC
+	go c.gc(c.clock.NewTicker(c.cleanupInterval), c.gcStop, c.gcDone)
+}
+
+// StopGC - остановка сборщика мусора с ожиданием завершения его горутины
+func (c *Cache[K, V]) StopGC() {
+	c.gcMu.Lock()
+	stop, done := c.gcStop, c.gcDone
+	c.gcStop, c.gcDone = nil, nil
+	c.gcMu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Close - освобождение ресурсов кэша, останавливает сборщик мусора
+func (c *Cache[K, V]) Close() error {
+	c.StopGC()
+	return nil
+}
+
+// Сборщик мусора, срабатывает по ticker и работает до закрытия stop
+func (c *Cache[K, V]) gc(ticker Ticker, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	// Ожидаем время установленное в cleanupInterval
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			c.DeleteExpired()
+		}
+	}
+}
+
+// DeleteExpired - удаление всех элементов с истекшим временем жизни, возвращает количество удаленных.
+// Сегменты обрабатываются по очереди, поэтому блокируется только один из них
+func (c *Cache[K, V]) DeleteExpired() int {
+	n := 0
+	now := c.clock.Now().UnixNano()
+	for _, s := range c.shards {
+		evicted := s.deleteExpired(now)
+		c.evictedExpired.Add(uint64(len(evicted)))
+		c.notifyEvicted(evicted)
+		n += len(evicted)
+	}
+	return n
+}
+
+// expired - проверка истечения времени жизни элемента на момент now
+func (i CacheItem[V]) expired(now int64) bool {
+	return i.Expiration > 0 && now > i.Expiration
+}
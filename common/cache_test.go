@@ -0,0 +1,459 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testStart - начальное время часов в тестах
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestCache - кэш с поддельными часами и временем жизни по умолчанию defaultExpiration
+func newTestCache(defaultExpiration time.Duration) (*Cache[string, int], *FakeClock) {
+	clock := NewFakeClock(testStart)
+	return NewCacheWithConfig[string, int](CacheConfig{DefaultExpiration: defaultExpiration, Clock: clock}), clock
+}
+
+func TestCacheSetGet(t *testing.T) {
+	tests := []struct {
+		name      string
+		duration  time.Duration
+		advance   time.Duration
+		key       string
+		wantValue int
+		wantFound bool
+	}{
+		{name: "fresh", duration: time.Minute, key: "a", wantValue: 1, wantFound: true},
+		{name: "before expiration", duration: time.Minute, advance: time.Minute, key: "a", wantValue: 1, wantFound: true},
+		{name: "expired", duration: time.Minute, advance: time.Minute + time.Nanosecond, key: "a", wantValue: 0, wantFound: false},
+		{name: "no expiration", duration: -1, advance: 24 * time.Hour, key: "a", wantValue: 1, wantFound: true},
+		{name: "missing key", duration: time.Minute, key: "b", wantValue: 0, wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := newTestCache(0)
+			c.Set("a", 1, tt.duration)
+			clock.Advance(tt.advance)
+
+			got, found := c.Get(tt.key)
+			if got != tt.wantValue || found != tt.wantFound {
+				t.Fatalf("Get(%q) = %d, %v; want %d, %v", tt.key, got, found, tt.wantValue, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestCacheSetOverwrites(t *testing.T) {
+	c, _ := newTestCache(0)
+	c.Set("a", 1, 0)
+	c.Set("a", 2, 0)
+
+	if got, _ := c.Get("a"); got != 2 {
+		t.Fatalf("Get after overwrite = %d, want 2", got)
+	}
+	if n := c.Len(); n != 1 {
+		t.Fatalf("Len after overwrite = %d, want 1", n)
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(c *Cache[string, int], clock *FakeClock)
+		wantErr error
+	}{
+		{name: "existing", setup: func(c *Cache[string, int], _ *FakeClock) { c.Set("a", 1, 0) }},
+		{name: "missing", setup: func(*Cache[string, int], *FakeClock) {}, wantErr: ErrKeyNotFound},
+		{name: "deleted twice", setup: func(c *Cache[string, int], _ *FakeClock) {
+			c.Set("a", 1, 0)
+			c.Delete("a")
+		}, wantErr: ErrKeyNotFound},
+		// Устаревший, но еще не удаленный сборщиком мусора элемент удаляется
+		{name: "expired", setup: func(c *Cache[string, int], clock *FakeClock) {
+			c.Set("a", 1, time.Second)
+			clock.Advance(time.Minute)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := newTestCache(0)
+			tt.setup(c, clock)
+
+			if err := c.Delete("a"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Delete = %v, want %v", err, tt.wantErr)
+			}
+			if _, found := c.Get("a"); found {
+				t.Fatal("key found after Delete")
+			}
+			if n := c.Len(); n != 0 {
+				t.Fatalf("Len after Delete = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestCacheRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		stopAt   int
+		wantKeys []string
+	}{
+		// Устаревший "b" пропускается
+		{name: "all", stopAt: -1, wantKeys: []string{"a", "c"}},
+		{name: "stop after first", stopAt: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := newTestCache(0)
+			c.Set("a", 1, time.Hour)
+			c.Set("b", 2, time.Second)
+			c.Set("c", 3, -1)
+			clock.Advance(time.Minute)
+
+			var keys []string
+			c.Range(func(key string, value int) bool {
+				keys = append(keys, key)
+				return len(keys) != tt.stopAt
+			})
+
+			if tt.stopAt > 0 {
+				if len(keys) != tt.stopAt {
+					t.Fatalf("Range visited %d keys after stop, want %d", len(keys), tt.stopAt)
+				}
+				return
+			}
+			sort.Strings(keys)
+			if len(keys) != len(tt.wantKeys) {
+				t.Fatalf("Range keys = %v, want %v", keys, tt.wantKeys)
+			}
+			for i := range keys {
+				if keys[i] != tt.wantKeys[i] {
+					t.Fatalf("Range keys = %v, want %v", keys, tt.wantKeys)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheLen(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(c *Cache[string, int], clock *FakeClock)
+		wantLen int
+	}{
+		{name: "empty", setup: func(*Cache[string, int], *FakeClock) {}, wantLen: 0},
+		{name: "distinct keys", setup: func(c *Cache[string, int], _ *FakeClock) {
+			c.Set("a", 1, 0)
+			c.Set("b", 2, 0)
+		}, wantLen: 2},
+		// Len считает и устаревшие элементы, пока их не удалил сборщик мусора
+		{name: "expired not collected", setup: func(c *Cache[string, int], clock *FakeClock) {
+			c.Set("a", 1, time.Second)
+			clock.Advance(time.Minute)
+		}, wantLen: 1},
+		{name: "expired collected", setup: func(c *Cache[string, int], clock *FakeClock) {
+			c.Set("a", 1, time.Second)
+			c.Set("b", 2, -1)
+			clock.Advance(time.Minute)
+			c.DeleteExpired()
+		}, wantLen: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := newTestCache(0)
+			tt.setup(c, clock)
+
+			if n := c.Len(); n != tt.wantLen {
+				t.Fatalf("Len = %d, want %d", n, tt.wantLen)
+			}
+		})
+	}
+}
+
+// BenchmarkCacheMixed - параллельные чтения и записи (9 к 1) с одним шардом и с 32 шардами
+func BenchmarkCacheMixed(b *testing.B) {
+	const keys = 1024
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	for _, shards := range []int{1, 32} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := NewCacheWithConfig[string, int](CacheConfig{DefaultExpiration: time.Hour, Shards: shards})
+			for i, name := range names {
+				c.Set(name, i, 0)
+			}
+			var seed atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(seed.Add(1)))
+				for pb.Next() {
+					n := r.Intn(keys)
+					if r.Intn(10) == 0 {
+						c.Set(names[n], n, 0)
+					} else {
+						c.Get(names[n])
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestCacheDefaultExpiration(t *testing.T) {
+	tests := []struct {
+		name              string
+		defaultExpiration time.Duration
+		duration          time.Duration
+		advance           time.Duration
+		wantFound         bool
+	}{
+		{name: "ttl 0 uses default, before", defaultExpiration: time.Minute, advance: time.Minute, wantFound: true},
+		{name: "ttl 0 uses default, after", defaultExpiration: time.Minute, advance: time.Minute + time.Second, wantFound: false},
+		{name: "own ttl overrides default", defaultExpiration: time.Minute, duration: time.Hour, advance: 2 * time.Minute, wantFound: true},
+		{name: "negative ttl never expires", defaultExpiration: time.Minute, duration: -1, advance: 24 * time.Hour, wantFound: true},
+		{name: "no default never expires", defaultExpiration: 0, advance: 24 * time.Hour, wantFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := newTestCache(tt.defaultExpiration)
+			c.Set("a", 1, tt.duration)
+			clock.Advance(tt.advance)
+
+			if _, found := c.Get("a"); found != tt.wantFound {
+				t.Fatalf("Get found = %v, want %v", found, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestCacheDeleteExpired(t *testing.T) {
+	c, clock := newTestCache(time.Minute)
+	var expired []string
+	c.OnExpired(func(key string, _ int) { expired = append(expired, key) })
+	c.Set("a", 1, 0)
+	c.Set("b", 2, time.Hour)
+	c.Set("c", 3, -1)
+
+	if n := c.DeleteExpired(); n != 0 {
+		t.Fatalf("DeleteExpired before expiration = %d, want 0", n)
+	}
+	clock.Advance(2 * time.Minute)
+	if n := c.DeleteExpired(); n != 1 {
+		t.Fatalf("DeleteExpired = %d, want 1", n)
+	}
+	if len(expired) != 1 || expired[0] != "a" {
+		t.Fatalf("OnExpired keys = %v, want [a]", expired)
+	}
+	if n := c.Len(); n != 2 {
+		t.Fatalf("Len = %d, want 2", n)
+	}
+	if n := c.DeleteExpired(); n != 0 {
+		t.Fatalf("second DeleteExpired = %d, want 0", n)
+	}
+}
+
+// newTestGCCache - кэш со сборщиком мусора на поддельных часах и каналом удаленных им ключей
+func newTestGCCache(t *testing.T) (*Cache[string, int], *FakeClock, <-chan string) {
+	t.Helper()
+	clock := NewFakeClock(testStart)
+	c := NewCacheWithConfig[string, int](CacheConfig{DefaultExpiration: time.Minute, CleanupInterval: 3 * time.Minute, Clock: clock})
+	t.Cleanup(func() { c.Close() })
+	expired := make(chan string, 16)
+	c.OnExpired(func(key string, _ int) { expired <- key })
+	return c, clock, expired
+}
+
+// tickerCount - количество работающих тикеров поддельных часов
+func (c *FakeClock) tickerCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.tickers)
+}
+
+func TestCacheGC(t *testing.T) {
+	c, clock, expired := newTestGCCache(t)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, time.Hour)
+
+	// Элемент истек, но до срабатывания тикера сборщик его не удаляет
+	clock.Advance(2 * time.Minute)
+	if n := c.Len(); n != 2 {
+		t.Fatalf("Len before GC tick = %d, want 2", n)
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case key := <-expired:
+		if key != "a" {
+			t.Fatalf("GC removed %q, want a", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC did not run after ticker fired")
+	}
+	if n := c.Len(); n != 1 {
+		t.Fatalf("Len after GC = %d, want 1", n)
+	}
+}
+
+func TestCacheStopGC(t *testing.T) {
+	c, clock, expired := newTestGCCache(t)
+	if n := clock.tickerCount(); n != 1 {
+		t.Fatalf("tickers after start = %d, want 1", n)
+	}
+
+	c.StopGC()
+	if n := clock.tickerCount(); n != 0 {
+		t.Fatalf("tickers after StopGC = %d, want 0", n)
+	}
+	// Повторная остановка ничего не делает
+	c.StopGC()
+
+	c.Set("a", 1, 0)
+	clock.Advance(time.Hour)
+	if n := c.Len(); n != 1 {
+		t.Fatalf("Len after stopped GC = %d, want 1", n)
+	}
+
+	// После нового запуска интервал отсчитывается от StartGC
+	c.StartGC()
+	clock.Advance(3 * time.Minute)
+	select {
+	case key := <-expired:
+		if key != "a" {
+			t.Fatalf("GC removed %q, want a", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC did not run after restart")
+	}
+}
+
+func TestCacheAddReplace(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing - время жизни уже записанного значения 1, 0 - значения нет
+		existing    time.Duration
+		write       func(c *Cache[string, int]) error
+		wantErr     error
+		wantValue   int
+		wantPresent bool
+	}{
+		{name: "add missing", write: func(c *Cache[string, int]) error { return c.Add("a", 2, 0) }, wantValue: 2, wantPresent: true},
+		{name: "add existing", existing: time.Hour, write: func(c *Cache[string, int]) error { return c.Add("a", 2, 0) }, wantErr: ErrKeyExists, wantValue: 1, wantPresent: true},
+		// Устаревший элемент считается отсутствующим
+		{name: "add expired", existing: time.Second, write: func(c *Cache[string, int]) error { return c.Add("a", 2, 0) }, wantValue: 2, wantPresent: true},
+		{name: "replace existing", existing: time.Hour, write: func(c *Cache[string, int]) error { return c.Replace("a", 2, 0) }, wantValue: 2, wantPresent: true},
+		{name: "replace missing", write: func(c *Cache[string, int]) error { return c.Replace("a", 2, 0) }, wantErr: ErrKeyNotFound},
+		{name: "replace expired", existing: time.Second, write: func(c *Cache[string, int]) error { return c.Replace("a", 2, 0) }, wantErr: ErrKeyNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := newTestCache(time.Hour)
+			if tt.existing != 0 {
+				c.Set("a", 1, tt.existing)
+			}
+			clock.Advance(time.Minute)
+
+			if err := tt.write(c); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("write = %v, want %v", err, tt.wantErr)
+			}
+			if got, found := c.Get("a"); got != tt.wantValue || found != tt.wantPresent {
+				t.Fatalf("Get = %d, %v; want %d, %v", got, found, tt.wantValue, tt.wantPresent)
+			}
+		})
+	}
+}
+
+func TestCacheCompareAndSwap(t *testing.T) {
+	c, _ := newTestCache(0)
+	if _, err := c.CompareAndSwap("a", 0, 1, 0); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("CompareAndSwap missing = %v, want ErrKeyNotFound", err)
+	}
+
+	v1 := c.Upsert("a", 1, 0)
+	v2, err := c.CompareAndSwap("a", v1, 2, 0)
+	if err != nil {
+		t.Fatalf("CompareAndSwap current version = %v", err)
+	}
+	if v2 == v1 {
+		t.Fatalf("version not changed by CompareAndSwap: %d", v2)
+	}
+	// Запись по устаревшей версии отвергается и значение не меняет
+	if _, err = c.CompareAndSwap("a", v1, 3, 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("CompareAndSwap stale version = %v, want ErrVersionMismatch", err)
+	}
+	if got, version, found := c.GetWithVersion("a"); !found || got != 2 || version != v2 {
+		t.Fatalf("GetWithVersion = %d, %d, %v; want 2, %d, true", got, version, found, v2)
+	}
+	// Любая запись меняет версию
+	if v3 := c.Upsert("a", 4, 0); v3 == v2 {
+		t.Fatalf("version not changed by Upsert: %d", v3)
+	}
+	if _, err = c.CompareAndSwap("a", v2, 5, 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("CompareAndSwap after Upsert = %v, want ErrVersionMismatch", err)
+	}
+}
+
+func TestCacheGetOrLoadConcurrent(t *testing.T) {
+	const goroutines = 16
+	tests := []struct {
+		name      string
+		loadErr   error
+		wantValue int
+	}{
+		{name: "success", wantValue: 42},
+		{name: "error", loadErr: errors.New("load failed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCache(time.Hour)
+			var calls atomic.Int32
+			started := make(chan struct{})
+			release := make(chan struct{})
+			loader := func(key string) (int, error) {
+				if calls.Add(1) == 1 {
+					close(started)
+				}
+				<-release
+				return tt.wantValue, tt.loadErr
+			}
+
+			type result struct {
+				value int
+				err   error
+			}
+			results := make(chan result, goroutines)
+			for i := 0; i < goroutines; i++ {
+				go func() {
+					value, err := c.GetOrLoad("a", loader)
+					results <- result{value, err}
+				}()
+			}
+			<-started
+			// Остальные промахи успевают присоединиться к идущей загрузке
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+
+			for i := 0; i < goroutines; i++ {
+				r := <-results
+				if r.value != tt.wantValue || !errors.Is(r.err, tt.loadErr) {
+					t.Fatalf("GetOrLoad = %d, %v; want %d, %v", r.value, r.err, tt.wantValue, tt.loadErr)
+				}
+			}
+			// Ошибка не сохраняется, поэтому промах, не успевший к первой загрузке, загружает заново
+			if n := calls.Load(); n != 1 && tt.loadErr == nil {
+				t.Fatalf("loader called %d times, want 1", n)
+			}
+			if _, found := c.Get("a"); found != (tt.loadErr == nil) {
+				t.Fatalf("Get found = %v after load, want %v", found, tt.loadErr == nil)
+			}
+		})
+	}
+}
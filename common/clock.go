@@ -0,0 +1,126 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock - источник текущего времени и тикеров кэша. Позволяет проверять истечение
+// времени жизни и работу сборщика мусора без ожидания реального времени
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker - тикер, полученный от Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock - системные часы, используются кэшем по умолчанию
+type SystemClock struct{}
+
+// Now - текущее системное время
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker - тикер с периодом d на основе time.Ticker
+func (SystemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTicker) Stop() {
+	t.t.Stop()
+}
+
+// FakeClock - часы, время которых меняется только вызовами Advance и Set
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers map[*fakeTicker]struct{}
+}
+
+// NewFakeClock - часы, показывающие время start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start, tickers: make(map[*fakeTicker]struct{})}
+}
+
+// Now - текущее время часов
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTicker - тикер с периодом d, срабатывающий при переводе часов
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{clock: c, period: d, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers[t] = struct{}{}
+	return t
+}
+
+// Advance - перевод часов вперед на d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setLocked(c.now.Add(d))
+}
+
+// Set - перевод часов на момент t
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setLocked(t)
+}
+
+// setLocked - перевод часов с отправкой тиков всем тикерам, чей срок наступил.
+// Как и у time.Ticker, непрочитанный тик не накапливается: пропущенные тики отбрасываются
+func (c *FakeClock) setLocked(t time.Time) {
+	c.now = t
+	for tk := range c.tickers {
+		for !tk.next.After(t) {
+			select {
+			case tk.c <- tk.next:
+			default:
+			}
+			tk.next = tk.next.Add(tk.period)
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	delete(t.clock.tickers, t)
+}
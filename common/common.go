@@ -2,7 +2,6 @@ package common
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,7 +11,7 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 )
 
@@ -109,151 +108,6 @@ func NewOrderGen() *Order {
 	return &Order{"orderUID" + strconv.Itoa(i), "trackNumber" + strconv.Itoa(i), "entry" + strconv.Itoa(i), *D, *P, I, "locale" + strconv.Itoa(i), "internalSignature" + strconv.Itoa(i), "customerID" + strconv.Itoa(i), "deliveryService" + strconv.Itoa(i), "shardkey" + strconv.Itoa(i), i, time.Now().Add(time.Duration(i) * time.Millisecond), "oofShard" + strconv.Itoa(i)}
 }
 
-// Структура кэша
-type Cache struct {
-	sync.RWMutex
-	defaultExpiration time.Duration
-	cleanupInterval   time.Duration
-	items             map[string]CacheItem
-}
-
-// Структура элемента кэша
-type CacheItem struct {
-	Value      interface{}
-	Created    time.Time
-	Expiration int64
-}
-
-// Инициализация кэша
-func NewCache(defaultExpiration, cleanupInterval time.Duration) *Cache {
-	items := make(map[string]CacheItem)
-
-	cache := Cache{
-		items:             items,
-		defaultExpiration: defaultExpiration,
-		cleanupInterval:   cleanupInterval,
-	}
-
-	// Если интервал очистки больше 0, запускаем очистку
-	if cleanupInterval > 0 {
-		cache.StartGC()
-	}
-
-	return &cache
-}
-
-func (c *Cache) Set(key string, value interface{}, duration time.Duration) {
-	var expiration int64
-
-	// Если продолжительность жизни равно 0 - используется значение по умолчанию
-	if duration == 0 {
-		duration = c.defaultExpiration
-	}
-
-	if duration > 0 {
-		expiration = time.Now().Add(duration).UnixNano()
-	}
-
-	c.Lock()
-
-	defer c.Unlock()
-
-	if _, ok := c.items[key]; ok == true {
-		fmt.Println("Owerriting is not allowed")
-		return
-	}
-	c.items[key] = CacheItem{
-		Value:      value,
-		Expiration: expiration,
-		Created:    time.Now(),
-	}
-}
-
-// Get - метод для получения данных из кэша
-
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.RLock()
-
-	defer c.RUnlock()
-
-	item, found := c.items[key]
-
-	// Ключ не найден
-	if !found {
-		return nil, false
-	}
-
-	// Проверка на установку времени истечения
-	if item.Expiration > 0 {
-		// Если в момент запроса кэш устарел возвращаем nil
-		if time.Now().UnixNano() > item.Expiration {
-			return nil, false
-		}
-	}
-
-	return item.Value, true
-
-}
-
-func (c *Cache) Delete(key string) error {
-	c.Lock()
-
-	defer c.Unlock()
-
-	if _, found := c.items[key]; !found {
-		return errors.New("Key not found!")
-	}
-
-	delete(c.items, key)
-
-	return nil
-
-}
-
-// Запуск сборщика мусора в горутине
-func (c *Cache) StartGC() {
-	go c.GC()
-}
-
-// Сборщик мусора
-func (c *Cache) GC() {
-	for {
-		// Ожидаем время установленное в cleanupInterval
-		<-time.After(c.cleanupInterval)
-		if c.items == nil {
-			return
-		}
-		// Ищем элементы с истекшим временем жизни и удаляем из хранилища
-		if keys := c.expiredKeys(); len(keys) != 0 {
-			c.clearItems(keys)
-		}
-	}
-}
-
-// Функция expiredKeys возвращает список "просроченных" ключей
-func (c *Cache) expiredKeys() (keys []string) {
-	c.RLock()
-
-	defer c.RUnlock()
-
-	for k, i := range c.items {
-		if time.Now().UnixNano() > i.Expiration && i.Expiration > 0 {
-			keys = append(keys, k)
-		}
-	}
-	return
-}
-
-// clearItems удаляет ключи из переданного списка
-func (c *Cache) clearItems(keys []string) {
-	c.Lock()
-	defer c.Unlock()
-
-	for _, k := range keys {
-		delete(c.items, k)
-	}
-}
-
 // Connector - структура для подключения к БД
 type Connector struct {
 	Uname  string
@@ -263,14 +117,46 @@ type Connector struct {
 	DBname string
 }
 
+// ErrOrderNotFound - заказа с таким OrderUID нет в БД
+var ErrOrderNotFound = errors.New("Order not found")
+
+// notFoundTTL - сколько помнится, что заказа нет в БД. Повторные запросы несуществующих
+// заказов в течение этого времени не доходят до БД
+const notFoundTTL = 30 * time.Second
+
+// notFoundMaxEntries - лимит количества запомненных несуществующих заказов
+const notFoundMaxEntries = 10000
+
+// Значения по умолчанию для All.DBTimeout и All.MessageTimeout
+const (
+	defaultDBTimeout      = 5 * time.Second
+	defaultMessageTimeout = 20 * time.Second
+)
+
 // All - структура со всем, что может понадобиться для работы
 type All struct {
 	Connctr    Connector
 	Ordr       Order
 	Pool       *pgxpool.Pool
-	Cch        *Cache
+	Store      OrderStore
+	Batcher    *OrderBatcher
+	Cch        OrderCache
 	StreamConn stan.Conn
 	StreamSubs stan.Subscription
+
+	// DBTimeout - ограничение времени одного обращения к БД при чтении заказов
+	// (запросы HTTP, опережающее обновление кэша, страницы и пачки прогрева), 0 - без ограничения
+	DBTimeout time.Duration
+	// MessageTimeout - ограничение времени сохранения заказа из одного сообщения, 0 - без ограничения
+	MessageTimeout time.Duration
+
+	// ctx - контекст фоновой работы и запросов, отменяемый Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	// notFound - OrderUID, которых не оказалось в БД (отрицательный кэш)
+	notFound *Cache[string, struct{}]
+	warmup   warmupState
+	ingest   ingestCounters
 }
 
 // GetPGSQL - метод для генерации строки
@@ -278,145 +164,162 @@ func (c Connector) GetPGSQL() string {
 	return fmt.Sprintf("postgresql://%s:%s@%s:%s/%s", c.Uname, c.Pass, c.Host, c.Port, c.DBname)
 }
 
-// NewAll - метод для создания новой структуры с коннектором и временными интервалами кэша
-func NewAll(c Connector, defaultExpiration, cleanupInterval time.Duration) *All {
-	return &All{Connctr: c, Cch: NewCache(defaultExpiration, cleanupInterval)}
+// NewAll - метод для создания новой структуры с коннектором и кэшем заказов.
+// Заказы с опережающим обновлением кэш перезагружает из БД
+func NewAll(c Connector, cache OrderCache) *All {
+	a := &All{Connctr: c, Cch: cache, DBTimeout: defaultDBTimeout, MessageTimeout: defaultMessageTimeout}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	// Первыми вытесняются самые старые записи, устаревшие удаляются при вытеснении, поэтому сборщик мусора не нужен
+	a.notFound = NewCacheWithConfig[string, struct{}](CacheConfig{DefaultExpiration: notFoundTTL, MaxEntries: notFoundMaxEntries})
+	cache.SetRefresher(func(uid string) (Order, error) {
+		return a.loadOrder(a.ctx, uid)
+	})
+	return a
 }
 
-// FromDbToCacheByKey - метод для подгрузки данных из БД в кэш, если в заказе есть номер
-func (a *All) FromDbToCacheByKey() error {
-	if a.Ordr.OrderUID == "" {
-		return fmt.Errorf("Key is empty")
-	}
-	var DelId, PayId sql.NullString
-	query := `Select TrackNumber, Entry, Deliveries, Pays, Items, Locale, InternalSignature, CustomerID, DeliveryService, Shardkey, SmID, DateCreated, OofShard from orders where orderUID = $1`
-	rows, err := a.Pool.Query(context.TODO(), query, a.Ordr.OrderUID)
-	if err != nil {
-		fmt.Errorf("Select from Order failed: %v", err)
-	}
-	it := make([]int, 0)
-	for rows.Next() {
-		err = rows.Scan(&a.Ordr.TrackNumber, &a.Ordr.Entry, &DelId, &PayId, &it, &a.Ordr.Locale, &a.Ordr.InternalSignature, &a.Ordr.CustomerID, &a.Ordr.DeliveryService, &a.Ordr.Shardkey, &a.Ordr.SmID, &a.Ordr.DateCreated, &a.Ordr.OofShard)
-		if err != nil {
-			return fmt.Errorf("Scanning rows from selected order failed: %v", err)
-		}
-	}
+// Context - контекст, который отменяется при Close. От него стоит порождать контексты
+// HTTP-запросов (http.Server.BaseContext), чтобы при завершении прерывались и они
+func (a *All) Context() context.Context {
+	return a.ctx
+}
 
-	for i := 0; i < len(it); i++ {
-		query = `Select chrtid, TrackNumber, Price, Rid, Item_name, Sale, Size, TotalPrice, NmID, Brand, Status from item where chrtid = $1`
-		rows, err := a.Pool.Query(context.TODO(), query, it[i])
-		if err != nil {
-			return fmt.Errorf("Select from Items failed: %v", err)
-		}
-		for rows.Next() {
-			var utem Item
-			err = rows.Scan(&utem.ChrtID, &utem.TrackNumber, &utem.Price, &utem.Rid, &utem.Name, &utem.Sale, &utem.Size, &utem.TotalPrice, &utem.NmID, &utem.Brand, &utem.Status)
-			if err != nil {
-				return fmt.Errorf("Scanning rows from selected items failed: %v", err)
-			}
-			a.Ordr.Items = append(a.Ordr.Items, utem)
-		}
-	}
-	query = `Select del_name, Phone, Zip, City, Address, Region, Email from delivery where del_id = $1`
-	rows, err = a.Pool.Query(context.TODO(), query, DelId)
-	if err != nil {
-		return fmt.Errorf("Select from Delivery failed: %v", err)
-	}
-	for rows.Next() {
-		err = rows.Scan(&a.Ordr.Deliveries.Name, &a.Ordr.Deliveries.Phone, &a.Ordr.Deliveries.Zip, &a.Ordr.Deliveries.City, &a.Ordr.Deliveries.Address, &a.Ordr.Deliveries.Region, &a.Ordr.Deliveries.Email)
-		if err != nil {
-			return fmt.Errorf("Scanning rows from selected delivery failed: %v", err)
-		}
-	}
-	query = `select Transaction, RequestID, Currency, Provider, Amount, PaymentDt, Bank, DeliveryCost, GoodsTotal, CustomFee from payment where pay_id = $1`
-	rows, err = a.Pool.Query(context.TODO(), query, PayId)
-	if err != nil {
-		return fmt.Errorf("Select from Payment failed: %v", err)
-	}
-	for rows.Next() {
-		err = rows.Scan(&a.Ordr.Pays.Transaction, &a.Ordr.Pays.RequestID, &a.Ordr.Pays.Currency, &a.Ordr.Pays.Provider, &a.Ordr.Pays.Amount, &a.Ordr.Pays.PaymentDt, &a.Ordr.Pays.Bank, &a.Ordr.Pays.DeliveryCost, &a.Ordr.Pays.GoodsTotal, &a.Ordr.Pays.CustomFee)
-		if err != nil {
-			return fmt.Errorf("Scanning rows from selected payments failed: %v", err)
-		}
-	}
+// Close - отмена выполняющихся обращений к БД: опережающих обновлений кэша и запросов,
+// порожденных от Context. Соединения с БД и сервером сообщений не закрывает
+func (a *All) Close() {
+	a.cancel()
+}
 
-	a.Cch.Set(a.Ordr.OrderUID, a.Ordr, 5*time.Minute)
-	return nil
+// withTimeout - контекст, отменяемый вместе с parent и ограниченный временем d, 0 - без ограничения
+func withTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, d)
 }
 
-// UploadCache - метод для добавления данных из БД в кэш при старте программы
-func (a *All) UploadCache() error {
-	query := `select orderuid from orders`
-	rows, err := a.Pool.Query(context.TODO(), query)
+// FromDbToCacheByKey - метод для подгрузки данных из БД в кэш, если в заказе есть номер
+func (a *All) FromDbToCacheByKey(ctx context.Context) error {
+	o, err := a.loadOrder(ctx, a.Ordr.OrderUID)
 	if err != nil {
-		fmt.Println(time.Now(), "Query error: ", err)
 		return err
 	}
-	for rows.Next() {
-		err = rows.Scan(&a.Ordr.OrderUID)
-		if err != nil {
-			fmt.Println(time.Now(), "rows scanning error:", err)
-			return err
-		}
-		err = a.FromDbToCacheByKey()
-		if err != nil {
-			fmt.Println(time.Now(), "caching data from db going wrong:", err)
-			return err
-		}
-	}
+	a.Ordr = o
+	return a.Cch.Set(a.Ordr.OrderUID, a.Ordr, 0)
+}
 
-	return nil
+// loadOrder - чтение заказа из хранилища не дольше DBTimeout, если заказа нет - ErrOrderNotFound
+func (a *All) loadOrder(ctx context.Context, uid string) (Order, error) {
+	ctx, cancel := withTimeout(ctx, a.DBTimeout)
+	defer cancel()
+	return a.Store.Get(ctx, uid)
 }
 
-// MessageHandler - обработчик сообщений из канала nats-streaming
+// ErrBadMessage - сообщение с заказом не может быть сохранено ни при какой повторной доставке:
+// не разбирается как JSON или нарушает ограничения БД
+var ErrBadMessage = errors.New("Bad order message")
+
+// MessageHandler - обработчик сообщений из канала nats-streaming. Подписка должна быть в режиме
+// ручного подтверждения (stan.SetManualAckMode): сообщение подтверждается, только если заказ сохранен
+// или сохранить его невозможно (ErrBadMessage), иначе сервер доставит его повторно по истечении AckWait.
+// Если задан Batcher, заказ записывается в составе пачки, а сообщение подтверждается после ее записи
 func (a *All) MessageHandler(m *stan.Msg) {
-	err := json.Unmarshal(m.Data, &a.Ordr)
-	if err != nil {
-		fmt.Println(err, "Json")
+	if a.Batcher == nil {
+		// Сохранение ограничено MessageTimeout и прерывается при Close
+		ctx, cancel := withTimeout(a.ctx, a.MessageTimeout)
+		defer cancel()
+		a.ackMessage(m, a.HandleMessage(ctx, m.Data))
 		return
 	}
-	var ResultDelivery, ResultPayment, ResultOrder string
-	var ResultItems int
-	a.Cch.Set(a.Ordr.OrderUID, a.Ordr, 5*time.Minute)
-	fmt.Println(time.Now(), a.Ordr.OrderUID, "putted in cache")
+	a.submitMessage(m.Data, func(err error) {
+		a.ackMessage(m, err)
+	})
+}
 
-	query := "INSERT INTO delivery (del_name, Phone, Zip, City, Address, Region, Email)	Values ($1, $2, $3, $4, $5, $6, $7) returning del_id"
-	err = a.Pool.QueryRow(context.TODO(), query, a.Ordr.Deliveries.Name, a.Ordr.Deliveries.Phone, a.Ordr.Deliveries.Zip, a.Ordr.Deliveries.City, a.Ordr.Deliveries.Address, a.Ordr.Deliveries.Region, a.Ordr.Deliveries.Email).Scan(&ResultDelivery)
+// submitMessage - постановка заказа из сообщения в очередь Batcher. done вызывается ровно один раз
+// с тем же результатом, что у HandleMessage: после записи пачки или сразу, если сообщение не разбирается
+func (a *All) submitMessage(data []byte, done func(err error)) {
+	o, err := a.decodeMessage(data)
 	if err != nil {
-		fmt.Println(time.Now(), "Insert to Delivery failed:", err)
+		done(err)
+		return
+	}
+	a.Batcher.Submit(o, func(err error) {
+		done(a.afterSave(o, err))
+	})
+}
 
+// ackMessage - подтверждение сообщения по результату его обработки err
+func (a *All) ackMessage(m *stan.Msg, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrBadMessage):
+		fmt.Println(time.Now(), "message rejected:", err)
+	default:
+		fmt.Println(time.Now(), "message handling failed, waiting for redelivery:", err)
+		return
 	}
-	fmt.Println(time.Now(), "delivery =", ResultDelivery)
+	if err = m.Ack(); err != nil {
+		fmt.Println(time.Now(), "message ack failed:", err)
+	}
+}
 
-	query = "INSERT INTO payment (Transaction, RequestID, Currency, Provider, Amount, PaymentDt, Bank, DeliveryCost, GoodsTotal, CustomFee)	Values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning pay_id"
-	err = a.Pool.QueryRow(context.TODO(), query, a.Ordr.Pays.Transaction, a.Ordr.Pays.RequestID, a.Ordr.Pays.Currency, a.Ordr.Pays.Provider, a.Ordr.Pays.Amount, a.Ordr.Pays.PaymentDt, a.Ordr.Pays.Bank, a.Ordr.Pays.DeliveryCost, a.Ordr.Pays.GoodsTotal, a.Ordr.Pays.CustomFee).Scan(&ResultPayment)
+// HandleMessage - сохранение заказа из сообщения в БД одной транзакцией и, после ее фиксации, в кэш.
+// Обработка идемпотентна: повторно доставленный заказ, который уже сохранен, ничего не меняет и не считается ошибкой.
+// Ошибки, при которых повторная доставка бессмысленна, оборачивают ErrBadMessage. Сохранение прерывается отменой ctx
+func (a *All) HandleMessage(ctx context.Context, data []byte) error {
+	o, err := a.decodeMessage(data)
 	if err != nil {
-		fmt.Println(time.Now(), "Insert to Payment failed:", err)
+		return err
 	}
-	fmt.Println(time.Now(), "payment =", ResultPayment)
-
-	it := make([]int, len(a.Ordr.Items))
+	return a.afterSave(o, a.Store.Save(ctx, o))
+}
 
-	for i := 0; i < len(a.Ordr.Items); i++ {
-		it[i] = a.Ordr.Items[i].ChrtID
+// decodeMessage - заказ из сообщения, ошибка разбора оборачивает ErrBadMessage
+func (a *All) decodeMessage(data []byte) (Order, error) {
+	var o Order
+	if err := json.Unmarshal(data, &o); err != nil {
+		a.ingest.rejected.Add(1)
+		return o, fmt.Errorf("%w: %v", ErrBadMessage, err)
 	}
-
-	query = "INSERT INTO orders (OrderUID, TrackNumber, Entry, Deliveries, Pays, Items, Locale, InternalSignature, CustomerID, DeliveryService, Shardkey, SmID, DateCreated, OofShard)	Values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning OrderUID"
-	err = a.Pool.QueryRow(context.TODO(), query, a.Ordr.OrderUID, a.Ordr.TrackNumber, a.Ordr.Entry, ResultDelivery, ResultPayment, it, a.Ordr.Locale, a.Ordr.InternalSignature, a.Ordr.CustomerID, a.Ordr.DeliveryService, a.Ordr.Shardkey, a.Ordr.SmID, a.Ordr.DateCreated, a.Ordr.OofShard).Scan(&ResultOrder)
-	if err != nil {
-		fmt.Println(time.Now(), "Insert to Order failed:", err)
+	if o.OrderUID == "" {
+		a.ingest.rejected.Add(1)
+		return o, fmt.Errorf("%w: order_uid is empty", ErrBadMessage)
 	}
-	fmt.Println(time.Now(), "Order =", ResultOrder)
+	return o, nil
+}
 
-	for j := len(a.Ordr.Items) - 1; j > 0; j-- {
-		query = "INSERT INTO item (ChrtID, TrackNumber, Price, Rid, Item_name, Sale, Size, TotalPrice, NmID, Brand, Status, orderid)	Values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning ChrtID"
-		err = a.Pool.QueryRow(context.TODO(), query, a.Ordr.Items[j].ChrtID, a.Ordr.Items[j].TrackNumber, a.Ordr.Items[j].Price, a.Ordr.Items[j].Rid, a.Ordr.Items[j].Name, a.Ordr.Items[j].Sale, a.Ordr.Items[j].Size, a.Ordr.Items[j].TotalPrice, a.Ordr.Items[j].NmID, a.Ordr.Items[j].Brand, a.Ordr.Items[j].Status, a.Ordr.OrderUID).Scan(&ResultItems)
-		if err != nil {
-			fmt.Println(time.Now(), "Insert to Items failed:", err)
-		}
-		fmt.Println(time.Now(), "item =", ResultItems)
+// afterSave - учет результата сохранения заказа err в счетчиках и запись сохраненного заказа в кэш
+func (a *All) afterSave(o Order, err error) error {
+	switch {
+	case err == nil:
+		a.ingest.saved.Add(1)
+		fmt.Println(time.Now(), o.OrderUID, "saved to DB")
+	case errors.Is(err, ErrOrderDuplicate):
+		a.ingest.duplicates.Add(1)
+		fmt.Println(time.Now(), o.OrderUID, "already saved, duplicate skipped")
+		// Заказ могла сохранить другая реплика, а здесь его могли запросить до этого
+		a.notFound.Delete(o.OrderUID)
+		return nil
+	case errors.Is(err, ErrOrderExists):
+		// Побеждает первый сохраненный вариант заказа, повторная доставка этого не изменит
+		a.ingest.conflicts.Add(1)
+		return fmt.Errorf("%w: order %s: %v", ErrBadMessage, o.OrderUID, err)
+	case errors.Is(err, ErrOrderRejected):
+		a.ingest.rejected.Add(1)
+		return fmt.Errorf("%w: order %s: %v", ErrBadMessage, o.OrderUID, err)
+	default:
+		a.ingest.failed.Add(1)
+		return fmt.Errorf("Saving order %s failed: %w", o.OrderUID, err)
 	}
 
+	// Заказ мог запрашиваться до того, как пришел
+	a.notFound.Delete(o.OrderUID)
+	if err := a.Cch.Set(o.OrderUID, o, 0); err != nil {
+		fmt.Println(time.Now(), o.OrderUID, "caching failed:", err)
+	} else {
+		fmt.Println(time.Now(), o.OrderUID, "putted in cache")
+	}
+	return nil
 }
 
 // OrderHandler - обработчик http-запросов
@@ -424,7 +327,12 @@ func (a *All) OrderHandler(Writer http.ResponseWriter, Request *http.Request) {
 	var err error
 	switch Request.Method {
 	case "GET":
-		err = a.getHandler(Writer)
+		// GET с order_uid в строке запроса отдает заказ, как POST, но с поддержкой If-None-Match
+		if Ouid := Request.URL.Query().Get("order_uid"); Ouid != "" {
+			err = a.orderResponse(Writer, Request, Ouid)
+		} else {
+			err = a.getHandler(Writer)
+		}
 	case "POST":
 		err = a.postHandler(Writer, Request)
 	default:
@@ -436,6 +344,19 @@ func (a *All) OrderHandler(Writer http.ResponseWriter, Request *http.Request) {
 	}
 }
 
+// StatsHandler - обработчик http-запросов статистики кэша, отдает CacheStats в формате JSON
+func (a *All) StatsHandler(Writer http.ResponseWriter, Request *http.Request) {
+	if Request.Method != "GET" {
+		http.Error(Writer, "Invalid request method", 405)
+		return
+	}
+	Writer.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(Writer).Encode(a.Cch.Stats())
+	if err != nil {
+		http.Error(Writer, err.Error(), 500)
+	}
+}
+
 // getHandler - обработчик GET-запроса
 func (a *All) getHandler(Writer http.ResponseWriter) error {
 	tmpl, err := template.ParseFiles("index.html")
@@ -445,25 +366,158 @@ func (a *All) getHandler(Writer http.ResponseWriter) error {
 	return tmpl.Execute(Writer, nil)
 }
 
-// postHandler - обработчик POST-запроса
+// postHandler - обработчик POST-запроса. Заказ ищется по order_uid,
+// а если он не задан - по первому заполненному полю вторичного индекса кэша
 func (a *All) postHandler(Writer http.ResponseWriter, Request *http.Request) error {
 	Ouid := Request.PostFormValue("order_uid")
-	Value, found := a.Cch.Get(Ouid)
-	if !found {
-		fmt.Fprintf(Writer, "Reading from DB:\n")
-		a.Ordr.OrderUID = Ouid
-		err := a.FromDbToCacheByKey()
-		if err != nil {
+	if Ouid == "" {
+		return a.lookupHandler(Writer, Request)
+	}
+	return a.orderResponse(Writer, Request, Ouid)
+}
+
+// orderResponse - ответ с заказом Ouid в формате JSON. Заказ отдается в том виде, в котором
+// он был сериализован при записи в кэш: с отступами, а при format=compact - компактным.
+// Откуда взят заказ, сообщает заголовок X-Order-Source: cache или db.
+// Чтение из БД ограничено DBTimeout, тогда ответ - 504. Если клиент ушел, ожидание заказа прерывается
+func (a *All) orderResponse(Writer http.ResponseWriter, Request *http.Request, Ouid string) error {
+	// Одновременные запросы одного и того же заказа, которого нет в кэше, читают БД один раз,
+	// а заказы, которых недавно не оказалось в БД, не читаются вовсе. Общее чтение не зависит
+	// от запроса, который его начал: уход этого клиента не должен прерывать его для остальных,
+	// поэтому оно идет с контекстом a.ctx и отменяется только при Close
+	type loaded struct {
+		order   Order
+		encoded *Encoded
+		fromDB  bool
+		err     error
+	}
+	done := make(chan loaded, 1)
+	go func() {
+		var r loaded
+		r.order, r.encoded, r.err = a.Cch.GetOrLoadEncoded(Ouid, func(uid string) (Order, error) {
+			if _, missing := a.notFound.Get(uid); missing {
+				return Order{}, ErrOrderNotFound
+			}
+			r.fromDB = true
+			o, err := a.loadOrder(a.ctx, uid)
+			if errors.Is(err, ErrOrderNotFound) {
+				a.notFound.Set(uid, struct{}{}, 0)
+			}
+			return o, err
+		})
+		done <- r
+	}()
+
+	var r loaded
+	select {
+	case r = <-done:
+	case <-Request.Context().Done():
+		// Отвечать некому, заказ дочитается и попадет в кэш без этого запроса
+		return nil
+	}
+	Value, encoded, fromDB, err := r.order, r.encoded, r.fromDB, r.err
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(Writer, err.Error(), 404)
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(Writer, err.Error(), 504)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if encoded == nil {
+		if encoded, err = EncodeJSON(Value, false); err != nil {
 			return err
 		}
-		Value, _ = a.Cch.Get(a.Ordr.OrderUID)
+	}
+	if fromDB {
+		Writer.Header().Set("X-Order-Source", "db")
 	} else {
-		fmt.Fprintf(Writer, "Reading from Cache:\n")
+		Writer.Header().Set("X-Order-Source", "cache")
 	}
-	JsonValue, err := json.MarshalIndent(Value, "", "\t")
-	if err != nil {
-		return err
+	return writeEncoded(Writer, Request, encoded)
+}
+
+// writeEncoded - отдача сериализованного значения с ETag. Сжатые данные отдаются клиентам, принимающим gzip,
+// а на GET-запрос с совпадающим If-None-Match отвечаем 304 без тела
+func writeEncoded(Writer http.ResponseWriter, Request *http.Request, encoded *Encoded) error {
+	body, gz, tag := encoded.Indented, encoded.GzipIndented, encoded.ETag
+	if Request.FormValue("format") == "compact" {
+		body, gz, tag = encoded.JSON, encoded.GzipJSON, tag+"-c"
+	}
+	useGzip := gz != nil && acceptsGzip(Request)
+	if useGzip {
+		body, tag = gz, tag+"-gz"
 	}
-	_, err = fmt.Fprintf(Writer, string(JsonValue))
+	etag := `"` + tag + `"`
+
+	Writer.Header().Set("ETag", etag)
+	Writer.Header().Set("Vary", "Accept-Encoding")
+	if Request.Method == "GET" && etagMatches(Request.Header.Get("If-None-Match"), etag) {
+		Writer.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	Writer.Header().Set("Content-Type", "application/json")
+	if useGzip {
+		Writer.Header().Set("Content-Encoding", "gzip")
+	}
+	Writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, err := Writer.Write(body)
 	return err
 }
+
+// acceptsGzip - принимает ли клиент ответы, сжатые gzip
+func acceptsGzip(Request *http.Request) bool {
+	for _, enc := range strings.Split(Request.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// etagMatches - есть ли etag в значении заголовка If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupHandler - поиск заказов в кэше по track_number, customer_id, transaction или chrt_id,
+// ответ - массив найденных заказов в формате JSON
+func (a *All) lookupHandler(Writer http.ResponseWriter, Request *http.Request) error {
+	index, ok := a.Cch.(OrderIndex)
+	if !ok {
+		return fmt.Errorf("Cache has no secondary indexes")
+	}
+	for f := IndexField(0); f < indexFieldCount; f++ {
+		value := Request.PostFormValue(f.String())
+		if value == "" {
+			continue
+		}
+		orders := index.Lookup(f, value)
+		if len(orders) == 0 {
+			http.Error(Writer, "Orders not found", 404)
+			return nil
+		}
+		JsonValue, err := json.MarshalIndent(orders, "", "\t")
+		if err != nil {
+			return err
+		}
+		// Поиск по вторичным полям идет только по кэшу, поле поиска сообщает заголовок X-Order-Lookup
+		Writer.Header().Set("Content-Type", "application/json")
+		Writer.Header().Set("X-Order-Source", "cache")
+		Writer.Header().Set("X-Order-Lookup", f.String())
+		_, err = Writer.Write(JsonValue)
+		return err
+	}
+	http.Error(Writer, "Order UID or indexed field is required", 400)
+	return nil
+}
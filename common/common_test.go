@@ -0,0 +1,389 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAll - All с хранилищем в памяти и кэшем в памяти с вторичными индексами, закрывается вместе с тестом
+func newTestAll(t *testing.T) *All {
+	t.Helper()
+	return newTestAllWithCache(t, CacheConfig{DefaultExpiration: time.Hour})
+}
+
+// newTestAllWithCache - то же, что newTestAll, с кэшем в памяти с параметрами cfg
+func newTestAllWithCache(t *testing.T, cfg CacheConfig) *All {
+	t.Helper()
+	cache := NewIndexedOrderCache(NewMemoryOrderCache(cfg))
+	a := NewAll(Connector{}, cache)
+	a.Store = NewMemoryOrderStore()
+	t.Cleanup(func() {
+		a.Close()
+		cache.Close()
+	})
+	return a
+}
+
+// postForm - POST-запрос формы к OrderHandler
+func postForm(a *All, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	a.OrderHandler(rec, req)
+	return rec
+}
+
+func TestLookupHandler(t *testing.T) {
+	a := newTestAll(t)
+	o := *NewOrderGen()
+	if err := a.Cch.Set(o.OrderUID, o, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantStatus int
+		wantLookup string
+	}{
+		{name: "track number", form: url.Values{"track_number": {o.TrackNumber}}, wantStatus: http.StatusOK, wantLookup: "track_number"},
+		{name: "customer", form: url.Values{"customer_id": {o.CustomerID}}, wantStatus: http.StatusOK, wantLookup: "customer_id"},
+		{name: "not found", form: url.Values{"track_number": {"missing"}}, wantStatus: http.StatusNotFound},
+		{name: "no fields", form: url.Values{}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postForm(a, tt.form)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Content-Type = %q, want application/json", ct)
+			}
+			if src := rec.Header().Get("X-Order-Source"); src != "cache" {
+				t.Fatalf("X-Order-Source = %q, want cache", src)
+			}
+			if f := rec.Header().Get("X-Order-Lookup"); f != tt.wantLookup {
+				t.Fatalf("X-Order-Lookup = %q, want %q", f, tt.wantLookup)
+			}
+			// Тело - чистый JSON без текстовых префиксов
+			var orders []Order
+			if err := json.Unmarshal(rec.Body.Bytes(), &orders); err != nil {
+				t.Fatalf("body is not JSON: %v\n%s", err, rec.Body)
+			}
+			if len(orders) != 1 || orders[0].OrderUID != o.OrderUID {
+				t.Fatalf("orders = %+v, want [%s]", orders, o.OrderUID)
+			}
+		})
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	a := newTestAll(t)
+	o := *NewOrderGen()
+	changed := o
+	changed.TrackNumber = "changed"
+	encode := func(o Order) []byte {
+		data, err := json.Marshal(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// Сообщения обрабатываются по порядку, счетчики накапливаются
+	steps := []struct {
+		name      string
+		data      []byte
+		wantErr   error
+		wantStats IngestStats
+	}{
+		{name: "new", data: encode(o), wantStats: IngestStats{Saved: 1}},
+		{name: "duplicate", data: encode(o), wantStats: IngestStats{Saved: 1, Duplicates: 1}},
+		{name: "conflict", data: encode(changed), wantErr: ErrBadMessage, wantStats: IngestStats{Saved: 1, Duplicates: 1, Conflicts: 1}},
+		{name: "bad json", data: []byte("{"), wantErr: ErrBadMessage, wantStats: IngestStats{Saved: 1, Duplicates: 1, Conflicts: 1, Rejected: 1}},
+		{name: "empty uid", data: encode(Order{}), wantErr: ErrBadMessage, wantStats: IngestStats{Saved: 1, Duplicates: 1, Conflicts: 1, Rejected: 2}},
+	}
+	for _, step := range steps {
+		err := a.HandleMessage(context.Background(), step.data)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: HandleMessage = %v, want %v", step.name, err, step.wantErr)
+		}
+		if stats := a.IngestStats(); stats != step.wantStats {
+			t.Fatalf("%s: IngestStats = %+v, want %+v", step.name, stats, step.wantStats)
+		}
+	}
+
+	// Побеждает первый сохраненный вариант заказа и в хранилище, и в кэше
+	saved, err := a.Store.Get(context.Background(), o.OrderUID)
+	if err != nil {
+		t.Fatalf("Store.Get: %v", err)
+	}
+	if saved.TrackNumber != o.TrackNumber {
+		t.Fatalf("stored TrackNumber = %q, want %q", saved.TrackNumber, o.TrackNumber)
+	}
+	if cached, found := a.Cch.Peek(o.OrderUID); !found || cached.TrackNumber != o.TrackNumber {
+		t.Fatalf("cached order = %q, %v; want %q, true", cached.TrackNumber, found, o.TrackNumber)
+	}
+}
+
+func TestOrderHandler(t *testing.T) {
+	a := newTestAll(t)
+	o := *NewOrderGen()
+	// Заказ есть только в хранилище, в кэш он попадает при первом запросе
+	if err := a.Store.Save(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		uid        string
+		wantStatus int
+		wantSource string
+	}{
+		{name: "from db", method: "GET", uid: o.OrderUID, wantStatus: http.StatusOK, wantSource: "db"},
+		{name: "from cache", method: "GET", uid: o.OrderUID, wantStatus: http.StatusOK, wantSource: "cache"},
+		{name: "post", method: "POST", uid: o.OrderUID, wantStatus: http.StatusOK, wantSource: "cache"},
+		{name: "not found", method: "GET", uid: "missing", wantStatus: http.StatusNotFound},
+		{name: "not found again", method: "POST", uid: "missing", wantStatus: http.StatusNotFound},
+		{name: "bad method", method: "PUT", uid: o.OrderUID, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rec *httptest.ResponseRecorder
+			if tt.method == "POST" {
+				rec = postForm(a, url.Values{"order_uid": {tt.uid}})
+			} else {
+				rec = httptest.NewRecorder()
+				a.OrderHandler(rec, httptest.NewRequest(tt.method, "/?order_uid="+url.QueryEscape(tt.uid), nil))
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if src := rec.Header().Get("X-Order-Source"); src != tt.wantSource {
+				t.Fatalf("X-Order-Source = %q, want %q", src, tt.wantSource)
+			}
+			var got Order
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body is not JSON: %v\n%s", err, rec.Body)
+			}
+			if got.OrderUID != o.OrderUID {
+				t.Fatalf("OrderUID = %q, want %q", got.OrderUID, o.OrderUID)
+			}
+		})
+	}
+}
+
+func TestOrderHandlerEncoding(t *testing.T) {
+	a := newTestAllWithCache(t, CacheConfig{DefaultExpiration: time.Hour, EncodeJSON: true, EncodeGzip: true})
+	o := *NewOrderGen()
+	if err := a.Cch.Set(o.OrderUID, o, 0); err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeJSON(o, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + want.ETag + `"`
+
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		header     http.Header
+		wantStatus int
+		wantETag   string
+		wantGzip   bool
+		// wantBody - тело ответа после распаковки, nil - тела нет
+		wantBody []byte
+	}{
+		{name: "indented", method: "GET", wantStatus: http.StatusOK, wantETag: etag, wantBody: want.Indented},
+		{name: "compact", method: "GET", query: "&format=compact", wantStatus: http.StatusOK, wantETag: `"` + want.ETag + `-c"`, wantBody: want.JSON},
+		{name: "gzip", method: "GET", header: http.Header{"Accept-Encoding": {"deflate, gzip"}}, wantStatus: http.StatusOK,
+			wantETag: `"` + want.ETag + `-gz"`, wantGzip: true, wantBody: want.Indented},
+		{name: "gzip refused", method: "GET", header: http.Header{"Accept-Encoding": {"gzip;q=0"}}, wantStatus: http.StatusOK, wantETag: etag, wantBody: want.Indented},
+		{name: "not modified", method: "GET", header: http.Header{"If-None-Match": {`"other", ` + etag}}, wantStatus: http.StatusNotModified, wantETag: etag},
+		{name: "weak etag not modified", method: "GET", header: http.Header{"If-None-Match": {"W/" + etag}}, wantStatus: http.StatusNotModified, wantETag: etag},
+		// У сжатого ответа свой ETag
+		{name: "other representation", method: "GET", header: http.Header{"If-None-Match": {etag}, "Accept-Encoding": {"gzip"}}, wantStatus: http.StatusOK,
+			wantETag: `"` + want.ETag + `-gz"`, wantGzip: true, wantBody: want.Indented},
+		{name: "post ignores If-None-Match", method: "POST", header: http.Header{"If-None-Match": {etag}}, wantStatus: http.StatusOK, wantETag: etag, wantBody: want.Indented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.method == "POST" {
+				req = httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"order_uid": {o.OrderUID}}.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest("GET", "/?order_uid="+url.QueryEscape(o.OrderUID)+tt.query, nil)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			a.OrderHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.wantETag {
+				t.Fatalf("ETag = %s, want %s", got, tt.wantETag)
+			}
+			if gz := rec.Header().Get("Content-Encoding") == "gzip"; gz != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", rec.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+			body := rec.Body.Bytes()
+			if tt.wantGzip {
+				r, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("body is not gzip: %v", err)
+				}
+				if body, err = io.ReadAll(r); err != nil {
+					t.Fatalf("reading gzip body: %v", err)
+				}
+			}
+			if !bytes.Equal(body, tt.wantBody) {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// slowOrderStore - хранилище, чтение из которого не успевает за отведенное время
+type slowOrderStore struct {
+	*MemoryOrderStore
+}
+
+func (s slowOrderStore) Get(ctx context.Context, uid string) (Order, error) {
+	<-ctx.Done()
+	return Order{OrderUID: uid}, fmt.Errorf("Select from Order failed: %w", ctx.Err())
+}
+
+func TestOrderHandlerDBTimeout(t *testing.T) {
+	a := newTestAll(t)
+	a.Store = slowOrderStore{NewMemoryOrderStore()}
+	a.DBTimeout = time.Millisecond
+
+	rec := httptest.NewRecorder()
+	a.OrderHandler(rec, httptest.NewRequest("GET", "/?order_uid=slow", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusGatewayTimeout, rec.Body)
+	}
+}
+
+// blockingOrderStore - хранилище, чтение из которого сообщает о начале в started и ждет закрытия release
+type blockingOrderStore struct {
+	*MemoryOrderStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingOrderStore) Get(ctx context.Context, uid string) (Order, error) {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return s.MemoryOrderStore.Get(ctx, uid)
+	case <-ctx.Done():
+		return Order{}, fmt.Errorf("Select from Order failed: %w", ctx.Err())
+	}
+}
+
+// Уход клиента, начавшего общее чтение заказа, не прерывает его для других запросов того же заказа
+func TestOrderHandlerLoaderCanceled(t *testing.T) {
+	a := newTestAll(t)
+	o := *NewOrderGen()
+	store := blockingOrderStore{NewMemoryOrderStore(), make(chan struct{}, 2), make(chan struct{})}
+	if err := store.Save(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	a.Store = store
+	get := func(ctx context.Context) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			a.OrderHandler(rec, httptest.NewRequest("GET", "/?order_uid="+o.OrderUID, nil).WithContext(ctx))
+			done <- rec
+		}()
+		return done
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := get(ctx)
+	<-store.started
+	second := get(context.Background())
+	// Второй запрос успевает присоединиться к чтению первого
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-first
+	close(store.release)
+
+	rec := <-second
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second request status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if _, found := a.Cch.Peek(o.OrderUID); !found {
+		t.Fatal("order not cached after canceled first request")
+	}
+}
+
+// Заказ, запрошенный до того, как пришел, отдается после его сохранения, даже если его сохранила другая реплика
+func TestHandleMessageClearsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		// savedElsewhere - заказ уже сохранен в общей БД другой репликой: здесь он дубликат и в кэш не попадает
+		savedElsewhere bool
+	}{
+		{name: "saved"},
+		{name: "duplicate", savedElsewhere: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAll(t)
+			o := *NewOrderGen()
+			get := func() int {
+				rec := httptest.NewRecorder()
+				a.OrderHandler(rec, httptest.NewRequest("GET", "/?order_uid="+o.OrderUID, nil))
+				return rec.Code
+			}
+			if code := get(); code != http.StatusNotFound {
+				t.Fatalf("status before message = %d, want %d", code, http.StatusNotFound)
+			}
+
+			if tt.savedElsewhere {
+				if err := a.Store.Save(context.Background(), o); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, err := json.Marshal(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = a.HandleMessage(context.Background(), data); err != nil {
+				t.Fatalf("HandleMessage: %v", err)
+			}
+			if code := get(); code != http.StatusOK {
+				t.Fatalf("status after message = %d, want %d", code, http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Encoded - значение, заранее сериализованное в JSON, чтобы отдавать его без повторного кодирования
+type Encoded struct {
+	// Компактный JSON
+	JSON []byte
+	// JSON с отступами (табуляцией)
+	Indented []byte
+	// Сжатые gzip JSON и Indented, nil - сжатие не включено
+	GzipJSON     []byte
+	GzipIndented []byte
+	// Хэш компактного JSON, одинаковый для одинаковых значений
+	ETag string
+}
+
+// EncodeJSON - сериализация значения v, gz - сжимать ли результаты gzip
+func EncodeJSON(v any, gz bool) (*Encoded, error) {
+	compact, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var indented bytes.Buffer
+	if err = json.Indent(&indented, compact, "", "\t"); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(compact)
+	e := &Encoded{JSON: compact, Indented: indented.Bytes(), ETag: hex.EncodeToString(sum[:16])}
+
+	if gz {
+		if e.GzipJSON, err = gzipBytes(e.JSON); err != nil {
+			return nil, err
+		}
+		if e.GzipIndented, err = gzipBytes(e.Indented); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// gzipBytes - сжатие data gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// size - объем сериализованных данных в байтах, учитывается в лимите MaxBytes
+func (e *Encoded) size() int64 {
+	if e == nil {
+		return 0
+	}
+	return int64(len(e.JSON) + len(e.Indented) + len(e.GzipJSON) + len(e.GzipIndented) + len(e.ETag))
+}
@@ -0,0 +1,236 @@
+package common
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+)
+
+// EvictionPolicy - политика вытеснения элементов при достижении лимитов кэша
+type EvictionPolicy int
+
+const (
+	// PolicyTTL - элементы удаляются только по истечении времени жизни, а при переполнении
+	// вытесняется элемент, который истек бы раньше остальных
+	PolicyTTL EvictionPolicy = iota
+	// PolicyLRU - вытесняется элемент, к которому дольше всего не обращались
+	PolicyLRU
+	// PolicyLFU - вытесняется элемент, к которому обращались реже всего
+	PolicyLFU
+)
+
+// String - название политики вытеснения
+func (p EvictionPolicy) String() string {
+	switch p {
+	case PolicyTTL:
+		return "ttl"
+	case PolicyLRU:
+		return "lru"
+	case PolicyLFU:
+		return "lfu"
+	}
+	return "unknown"
+}
+
+// ParseEvictionPolicy - политика вытеснения по ее названию из String
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	for _, p := range []EvictionPolicy{PolicyTTL, PolicyLRU, PolicyLFU} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown eviction policy %q", s)
+}
+
+// evictor - учет порядка вытеснения ключей для конкретной политики.
+// Все методы вызываются под блокировкой кэша на запись
+type evictor[K comparable] interface {
+	// add - учет нового ключа
+	add(key K, expiration int64)
+	// access - учет обращения к ключу
+	access(key K)
+	// remove - прекращение учета ключа
+	remove(key K)
+	// victim - ключ, который следует вытеснить первым
+	victim() (K, bool)
+}
+
+// newEvictor - создание учета вытеснения для политики p. Политика TTL
+// использует индекс истечения сегмента expirations и не ведет собственного учета
+func newEvictor[K comparable](p EvictionPolicy, expirations *keyHeap[K]) evictor[K] {
+	switch p {
+	case PolicyLRU:
+		return newLRUEvictor[K]()
+	case PolicyLFU:
+		return newLFUEvictor[K]()
+	default:
+		return ttlEvictor[K]{expirations}
+	}
+}
+
+// lruEvictor - список ключей в порядке последнего обращения, в начале самые свежие
+type lruEvictor[K comparable] struct {
+	order *list.List
+	elems map[K]*list.Element
+}
+
+func newLRUEvictor[K comparable]() *lruEvictor[K] {
+	return &lruEvictor[K]{order: list.New(), elems: make(map[K]*list.Element)}
+}
+
+func (e *lruEvictor[K]) add(key K, _ int64) {
+	e.elems[key] = e.order.PushFront(key)
+}
+
+func (e *lruEvictor[K]) access(key K) {
+	if el, ok := e.elems[key]; ok {
+		e.order.MoveToFront(el)
+	}
+}
+
+func (e *lruEvictor[K]) remove(key K) {
+	if el, ok := e.elems[key]; ok {
+		e.order.Remove(el)
+		delete(e.elems, key)
+	}
+}
+
+func (e *lruEvictor[K]) victim() (key K, ok bool) {
+	el := e.order.Back()
+	if el == nil {
+		return key, false
+	}
+	return el.Value.(K), true
+}
+
+// heapEntry - элемент кучи ключей, упорядоченных по rank, при равенстве - по seq
+type heapEntry[K comparable] struct {
+	key   K
+	rank  int64
+	seq   uint64
+	index int
+}
+
+// keyHeap - минимальная куча ключей с индексом для удаления и обновления за O(log n)
+type keyHeap[K comparable] struct {
+	entries []*heapEntry[K]
+	index   map[K]*heapEntry[K]
+	seq     uint64
+}
+
+func newKeyHeap[K comparable]() *keyHeap[K] {
+	return &keyHeap[K]{index: make(map[K]*heapEntry[K])}
+}
+
+func (h *keyHeap[K]) Len() int { return len(h.entries) }
+
+func (h *keyHeap[K]) Less(i, j int) bool {
+	if h.entries[i].rank != h.entries[j].rank {
+		return h.entries[i].rank < h.entries[j].rank
+	}
+	return h.entries[i].seq < h.entries[j].seq
+}
+
+func (h *keyHeap[K]) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *keyHeap[K]) Push(x any) {
+	e := x.(*heapEntry[K])
+	e.index = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *keyHeap[K]) Pop() any {
+	n := len(h.entries) - 1
+	e := h.entries[n]
+	h.entries[n] = nil
+	h.entries = h.entries[:n]
+	return e
+}
+
+// set - добавление ключа или изменение его ранга
+func (h *keyHeap[K]) set(key K, rank int64) {
+	h.seq++
+	if e, ok := h.index[key]; ok {
+		e.rank, e.seq = rank, h.seq
+		heap.Fix(h, e.index)
+		return
+	}
+	e := &heapEntry[K]{key: key, rank: rank, seq: h.seq}
+	h.index[key] = e
+	heap.Push(h, e)
+}
+
+// rank - текущий ранг ключа
+func (h *keyHeap[K]) rank(key K) (int64, bool) {
+	e, ok := h.index[key]
+	if !ok {
+		return 0, false
+	}
+	return e.rank, true
+}
+
+// delete - удаление ключа из кучи
+func (h *keyHeap[K]) delete(key K) {
+	if e, ok := h.index[key]; ok {
+		heap.Remove(h, e.index)
+		delete(h.index, key)
+	}
+}
+
+// min - ключ с наименьшим рангом
+func (h *keyHeap[K]) min() (key K, rank int64, ok bool) {
+	if len(h.entries) == 0 {
+		return key, 0, false
+	}
+	return h.entries[0].key, h.entries[0].rank, true
+}
+
+// lfuEvictor - куча ключей по числу обращений, при равенстве вытесняется тот, к которому обращались раньше
+type lfuEvictor[K comparable] struct {
+	*keyHeap[K]
+}
+
+func newLFUEvictor[K comparable]() *lfuEvictor[K] {
+	return &lfuEvictor[K]{newKeyHeap[K]()}
+}
+
+func (e *lfuEvictor[K]) add(key K, _ int64) {
+	e.set(key, 1)
+}
+
+func (e *lfuEvictor[K]) access(key K) {
+	if freq, ok := e.rank(key); ok {
+		e.set(key, freq+1)
+	}
+}
+
+func (e *lfuEvictor[K]) remove(key K) {
+	e.delete(key)
+}
+
+func (e *lfuEvictor[K]) victim() (K, bool) {
+	key, _, ok := e.min()
+	return key, ok
+}
+
+// ttlEvictor - вытеснение по индексу истечения сегмента: первым вытесняется элемент,
+// который истек бы раньше остальных, бессрочные элементы вытесняются последними.
+// Индекс ведет сам сегмент, поэтому add и remove ничего не делают
+type ttlEvictor[K comparable] struct {
+	expirations *keyHeap[K]
+}
+
+func (e ttlEvictor[K]) add(K, int64) {}
+
+func (e ttlEvictor[K]) access(K) {}
+
+func (e ttlEvictor[K]) remove(K) {}
+
+func (e ttlEvictor[K]) victim() (K, bool) {
+	key, _, ok := e.expirations.min()
+	return key, ok
+}
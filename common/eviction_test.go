@@ -0,0 +1,139 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseEvictionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    EvictionPolicy
+		wantErr bool
+	}{
+		{name: "ttl", want: PolicyTTL},
+		{name: "lru", want: PolicyLRU},
+		{name: "lfu", want: PolicyLFU},
+		{name: "LRU", wantErr: true},
+		{name: "unknown", wantErr: true},
+		{name: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEvictionPolicy(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEvictionPolicy(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("ParseEvictionPolicy(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheEvictionPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		// use - записи и обращения к кэшу на 3 элемента перед записью четвертого
+		use        func(c *Cache[string, int])
+		wantVictim string
+	}{
+		{name: "lru", policy: PolicyLRU, use: func(c *Cache[string, int]) {
+			c.Set("a", 1, 0)
+			c.Set("b", 2, 0)
+			c.Set("c", 3, 0)
+			c.Get("a")
+		}, wantVictim: "b"},
+		{name: "lru peek is not access", policy: PolicyLRU, use: func(c *Cache[string, int]) {
+			c.Set("a", 1, 0)
+			c.Set("b", 2, 0)
+			c.Set("c", 3, 0)
+			c.Peek("a")
+		}, wantVictim: "a"},
+		{name: "lfu", policy: PolicyLFU, use: func(c *Cache[string, int]) {
+			c.Set("a", 1, 0)
+			c.Set("b", 2, 0)
+			c.Set("c", 3, 0)
+			c.Get("a")
+			c.Get("a")
+			c.Get("c")
+		}, wantVictim: "b"},
+		{name: "ttl", policy: PolicyTTL, use: func(c *Cache[string, int]) {
+			c.Set("a", 1, time.Hour)
+			c.Set("b", 2, time.Minute)
+			c.Set("c", 3, -1)
+			c.Get("b")
+		}, wantVictim: "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCacheWithConfig[string, int](CacheConfig{MaxEntries: 3, Shards: 1, Policy: tt.policy, Clock: NewFakeClock(testStart)})
+			var evicted []string
+			c.OnEvicted(func(key string, _ int, reason EvictionReason) {
+				if reason != ReasonCapacity {
+					t.Errorf("eviction reason of %q = %v, want capacity", key, reason)
+				}
+				evicted = append(evicted, key)
+			})
+			tt.use(c)
+			c.Set("d", 4, 0)
+
+			if len(evicted) != 1 || evicted[0] != tt.wantVictim {
+				t.Fatalf("evicted = %v, want [%s]", evicted, tt.wantVictim)
+			}
+			for _, key := range []string{"a", "b", "c", "d"} {
+				if _, found := c.Peek(key); found != (key != tt.wantVictim) {
+					t.Fatalf("Peek(%q) found = %v after eviction", key, found)
+				}
+			}
+			if stats := c.EvictionStats(); stats != (EvictionStats{Capacity: 1}) {
+				t.Fatalf("EvictionStats = %+v, want 1 capacity eviction", stats)
+			}
+		})
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	c := NewCacheWithConfig[string, int](CacheConfig{MaxBytes: 100, Shards: 1, Policy: PolicyLRU, Clock: NewFakeClock(testStart)})
+	// Размер элемента - его значение
+	c.SetSizer(func(_ string, v int) int64 { return int64(v) })
+
+	if err := c.Add("big", 101, 0); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Add of value larger than MaxBytes = %v, want ErrTooLarge", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Add(key, 40, 0); err != nil {
+			t.Fatalf("Add(%q): %v", key, err)
+		}
+	}
+	if _, found := c.Peek("a"); found {
+		t.Fatal("oldest entry not evicted on MaxBytes overflow")
+	}
+	if n := c.Bytes(); n != 80 {
+		t.Fatalf("Bytes = %d, want 80", n)
+	}
+	// Перезапись учитывает новый размер вместо старого, а не в дополнение к нему
+	c.Set("c", 60, 0)
+	if n, bytes := c.Len(), c.Bytes(); n != 2 || bytes != 100 {
+		t.Fatalf("after overwrite Len = %d, Bytes = %d; want 2, 100", n, bytes)
+	}
+	if stats := c.EvictionStats(); stats != (EvictionStats{Capacity: 1, Rejected: 1}) {
+		t.Fatalf("EvictionStats = %+v, want 1 capacity eviction and 1 rejected", stats)
+	}
+}
+
+func TestCacheEvictionStatsExpired(t *testing.T) {
+	c, clock := newTestCache(time.Minute)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	clock.Advance(time.Hour)
+
+	// Истекший элемент, перезаписанный до сборки мусора, тоже считается истекшим
+	c.Set("a", 3, 0)
+	c.DeleteExpired()
+	if stats := c.EvictionStats(); stats != (EvictionStats{Expired: 2}) {
+		t.Fatalf("EvictionStats = %+v, want 2 expired", stats)
+	}
+}
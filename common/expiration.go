@@ -0,0 +1,118 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExpirationMode - способ отсчета времени жизни элемента
+type ExpirationMode int
+
+const (
+	// ExpireAbsolute - элемент истекает через TTL после записи
+	ExpireAbsolute ExpirationMode = iota
+	// ExpireSliding - элемент истекает через TTL после последнего чтения или записи
+	ExpireSliding
+)
+
+// String - название способа отсчета
+func (m ExpirationMode) String() string {
+	switch m {
+	case ExpireAbsolute:
+		return "absolute"
+	case ExpireSliding:
+		return "sliding"
+	}
+	return "unknown"
+}
+
+// ParseExpirationMode - способ отсчета времени жизни по его названию из String
+func ParseExpirationMode(s string) (ExpirationMode, error) {
+	for _, m := range []ExpirationMode{ExpireAbsolute, ExpireSliding} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown expiration mode %q", s)
+}
+
+// ExpirationPolicy - правило истечения элемента кэша
+type ExpirationPolicy struct {
+	Mode ExpirationMode `json:"mode"`
+	// Время жизни, 0 - время жизни кэша по умолчанию, меньше 0 - бессрочно
+	TTL time.Duration `json:"ttl"`
+	// Если больше 0, то при чтении элемента, которому осталось жить меньше RefreshAhead,
+	// он перезагружается в фоне функцией, заданной SetRefresher
+	RefreshAhead time.Duration `json:"refresh_ahead"`
+}
+
+// needsRefresh - пора ли перезагружать элемент, истекающий в момент expiration, на момент now
+func (p ExpirationPolicy) needsRefresh(expiration, now int64) bool {
+	return p.RefreshAhead > 0 && expiration > 0 && now >= expiration-int64(p.RefreshAhead)
+}
+
+// policyFor - правило истечения для записи со временем жизни duration:
+// режим и опережающая перезагрузка берутся из правила по умолчанию
+func (c *Cache[K, V]) policyFor(duration time.Duration) ExpirationPolicy {
+	p := c.defaultPolicy
+	p.TTL = duration
+	return p
+}
+
+// resolve - подстановка времени жизни по умолчанию в правило p
+func (c *Cache[K, V]) resolve(p ExpirationPolicy) ExpirationPolicy {
+	// Если продолжительность жизни равно 0 - используется значение по умолчанию
+	if p.TTL == 0 {
+		p.TTL = c.defaultExpiration
+	}
+	return p
+}
+
+// SetWithPolicy - запись значения с перезаписью и собственным правилом истечения
+func (c *Cache[K, V]) SetWithPolicy(key K, value V, p ExpirationPolicy) error {
+	p = c.resolve(p)
+	now := c.clock.Now()
+	_, err := c.writeAt(key, value, now, expirationAt(now, p.TTL), p, nil)
+	return err
+}
+
+// SetRefresher - функция перезагрузки элементов с опережающим обновлением (RefreshAhead).
+// Должна вызываться до начала работы с кэшем
+func (c *Cache[K, V]) SetRefresher(refresher func(K) (V, error)) {
+	c.refresher = refresher
+}
+
+// scheduleRefresh - запуск фоновой перезагрузки ключа с правилом истечения p, если она еще не идет
+func (c *Cache[K, V]) scheduleRefresh(key K, p ExpirationPolicy) {
+	if c.refresher == nil {
+		return
+	}
+	if _, running := c.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer c.refreshing.Delete(key)
+
+		value, err := c.refresher(key)
+		if err != nil {
+			fmt.Println(time.Now(), "cache refresh failed:", err)
+			return
+		}
+		// Перезаписываем только существующий элемент, чтобы не вернуть удаленный
+		now := c.clock.Now()
+		c.writeAt(key, value, now, expirationAt(now, p.TTL), p, func(item CacheItem[V], found bool) error {
+			if !found {
+				return ErrKeyNotFound
+			}
+			return nil
+		})
+	}()
+}
+
+// expirationAt - момент истечения в наносекундах для времени жизни ttl, отсчитанного от now, 0 - бессрочно
+func expirationAt(now time.Time, ttl time.Duration) int64 {
+	if ttl > 0 {
+		return now.Add(ttl).UnixNano()
+	}
+	return 0
+}
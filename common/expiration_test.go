@@ -0,0 +1,27 @@
+package common
+
+import "testing"
+
+func TestParseExpirationMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    ExpirationMode
+		wantErr bool
+	}{
+		{name: "absolute", want: ExpireAbsolute},
+		{name: "sliding", want: ExpireSliding},
+		{name: "Sliding", wantErr: true},
+		{name: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExpirationMode(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpirationMode(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("ParseExpirationMode(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package common
+
+import "sync"
+
+// EvictionReason - причина удаления элемента из кэша
+type EvictionReason int
+
+const (
+	// ReasonExpired - истекло время жизни элемента
+	ReasonExpired EvictionReason = iota + 1
+	// ReasonCapacity - элемент вытеснен при достижении лимитов кэша
+	ReasonCapacity
+	// ReasonDeleted - элемент удален вызовом Delete
+	ReasonDeleted
+)
+
+// String - название причины удаления
+func (r EvictionReason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// evictedItem - удаленный элемент, о котором нужно сообщить обработчикам после снятия блокировки
+type evictedItem[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictionReason
+}
+
+// cacheHooks - обработчики событий кэша
+type cacheHooks[K comparable, V any] struct {
+	sync.RWMutex
+	evicted  []func(key K, value V, reason EvictionReason)
+	expired  []func(key K, value V)
+	inserted []func(key K, value V)
+}
+
+// OnEvicted - регистрация обработчика удаления элемента по любой причине.
+// Обработчики вызываются вне блокировок кэша, поэтому могут обращаться к нему
+func (c *Cache[K, V]) OnEvicted(f func(key K, value V, reason EvictionReason)) {
+	c.hooks.Lock()
+	defer c.hooks.Unlock()
+
+	c.hooks.evicted = append(c.hooks.evicted, f)
+}
+
+// OnExpired - регистрация обработчика удаления элемента по истечении времени жизни
+func (c *Cache[K, V]) OnExpired(f func(key K, value V)) {
+	c.hooks.Lock()
+	defer c.hooks.Unlock()
+
+	c.hooks.expired = append(c.hooks.expired, f)
+}
+
+// OnInserted - регистрация обработчика добавления или замены элемента
+func (c *Cache[K, V]) OnInserted(f func(key K, value V)) {
+	c.hooks.Lock()
+	defer c.hooks.Unlock()
+
+	c.hooks.inserted = append(c.hooks.inserted, f)
+}
+
+// notifyEvicted - вызов обработчиков для удаленных элементов
+func (c *Cache[K, V]) notifyEvicted(items []evictedItem[K, V]) {
+	if len(items) == 0 {
+		return
+	}
+
+	c.hooks.RLock()
+	evicted, expired := c.hooks.evicted, c.hooks.expired
+	c.hooks.RUnlock()
+
+	for _, i := range items {
+		for _, f := range evicted {
+			f(i.key, i.value, i.reason)
+		}
+		if i.reason != ReasonExpired {
+			continue
+		}
+		for _, f := range expired {
+			f(i.key, i.value)
+		}
+	}
+}
+
+// notifyInserted - вызов обработчиков для добавленного элемента
+func (c *Cache[K, V]) notifyInserted(key K, value V) {
+	c.hooks.RLock()
+	inserted := c.hooks.inserted
+	c.hooks.RUnlock()
+
+	for _, f := range inserted {
+		f(key, value)
+	}
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// hookEvent - вызов обработчика события кэша
+type hookEvent struct {
+	hook   string
+	key    string
+	reason EvictionReason
+	// found - нашелся ли ключ в кэше при обращении к нему из обработчика
+	found bool
+}
+
+func TestCacheHooks(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	c := NewCacheWithConfig[string, int](CacheConfig{DefaultExpiration: time.Hour, MaxEntries: 2, Shards: 1, Policy: PolicyLRU, Clock: clock})
+	var events []hookEvent
+	// Обработчики обращаются к кэшу: если бы они вызывались под блокировкой сегмента, это была бы взаимоблокировка
+	c.OnInserted(func(key string, value int) {
+		got, found := c.Get(key)
+		events = append(events, hookEvent{hook: "inserted", key: key, found: found && got == value})
+	})
+	c.OnEvicted(func(key string, _ int, reason EvictionReason) {
+		_, found := c.Get(key)
+		c.Len()
+		events = append(events, hookEvent{hook: "evicted", key: key, reason: reason, found: found})
+	})
+	c.OnExpired(func(key string, _ int) {
+		_, found := c.Peek(key)
+		events = append(events, hookEvent{hook: "expired", key: key, found: found})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Set("a", 1, 0)
+		c.Set("b", 2, 0)
+		c.Set("c", 3, 0)
+		c.Delete("b")
+		c.Set("d", 4, time.Second)
+		clock.Advance(time.Minute)
+		c.DeleteExpired()
+		c.Set("e", 5, time.Second)
+		clock.Advance(time.Minute)
+		// Перезапись истекшего элемента сообщает о его истечении
+		c.Set("e", 6, 0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cache deadlocked calling hooks")
+	}
+
+	want := []hookEvent{
+		{hook: "inserted", key: "a", found: true},
+		{hook: "inserted", key: "b", found: true},
+		{hook: "evicted", key: "a", reason: ReasonCapacity},
+		{hook: "inserted", key: "c", found: true},
+		{hook: "evicted", key: "b", reason: ReasonDeleted},
+		{hook: "inserted", key: "d", found: true},
+		{hook: "evicted", key: "d", reason: ReasonExpired},
+		{hook: "expired", key: "d"},
+		{hook: "inserted", key: "e", found: true},
+		// Об истекшем значении сообщается после записи нового, поэтому ключ уже есть в кэше
+		{hook: "evicted", key: "e", reason: ReasonExpired, found: true},
+		{hook: "expired", key: "e", found: true},
+		{hook: "inserted", key: "e", found: true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v\nwant %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// IngestStats - счетчики обработки сообщений с заказами
+type IngestStats struct {
+	// Сохранено новых заказов
+	Saved uint64 `json:"saved"`
+	// Повторно доставленных заказов, которые уже сохранены с тем же содержимым
+	Duplicates uint64 `json:"duplicates"`
+	// Заказов с уже сохраненным OrderUID, но другим содержимым; сохраненный заказ не меняется
+	Conflicts uint64 `json:"conflicts"`
+	// Сообщений, которые невозможно сохранить (ErrBadMessage, кроме конфликтов)
+	Rejected uint64 `json:"rejected"`
+	// Временных ошибок, после которых сообщение ждет повторной доставки
+	Failed uint64 `json:"failed"`
+}
+
+// ingestCounters - счетчики IngestStats, изменяемые одновременно несколькими обработчиками сообщений
+type ingestCounters struct {
+	saved      atomic.Uint64
+	duplicates atomic.Uint64
+	conflicts  atomic.Uint64
+	rejected   atomic.Uint64
+	failed     atomic.Uint64
+}
+
+// IngestStats - текущие значения счетчиков обработки сообщений
+func (a *All) IngestStats() IngestStats {
+	return IngestStats{
+		Saved:      a.ingest.saved.Load(),
+		Duplicates: a.ingest.duplicates.Load(),
+		Conflicts:  a.ingest.conflicts.Load(),
+		Rejected:   a.ingest.rejected.Load(),
+		Failed:     a.ingest.failed.Load(),
+	}
+}
+
+// IngestHandler - обработчик http-запросов счетчиков обработки сообщений, отдает IngestStats в формате JSON
+func (a *All) IngestHandler(Writer http.ResponseWriter, Request *http.Request) {
+	if Request.Method != "GET" {
+		http.Error(Writer, "Invalid request method", 405)
+		return
+	}
+	Writer.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(Writer).Encode(a.IngestStats())
+	if err != nil {
+		http.Error(Writer, err.Error(), 500)
+	}
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Broker - брокер сообщений, через который реплики клиента рассылают друг другу события кэша.
+// Handler вызывается для каждого сообщения, опубликованного в subject, в том числе самой репликой
+type Broker interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, handler func(data []byte)) (unsubscribe func() error, err error)
+}
+
+// NatsBroker - Broker поверх соединения с сервером NATS
+type NatsBroker struct {
+	Conn *nats.Conn
+}
+
+// Publish - публикация сообщения в subject
+func (b NatsBroker) Publish(subject string, data []byte) error {
+	return b.Conn.Publish(subject, data)
+}
+
+// Subscribe - подписка на сообщения subject. Отписка после закрытия соединения ошибкой не считается
+func (b NatsBroker) Subscribe(subject string, handler func(data []byte)) (func() error, error) {
+	sub, err := b.Conn.Subscribe(subject, func(m *nats.Msg) {
+		handler(m.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		err := sub.Unsubscribe()
+		if errors.Is(err, nats.ErrConnectionClosed) {
+			return nil
+		}
+		return err
+	}, nil
+}
+
+// invalidation - событие изменения заказа в кэше одной из реплик
+type invalidation struct {
+	// Origin - реплика, изменившая заказ
+	Origin string `json:"origin"`
+	UID    string `json:"order_uid"`
+}
+
+// InvalidatingOrderCache - кэш заказов, согласованный с кэшами других реплик клиента.
+// При записи (Set, SetWithPolicy) или удалении заказа реплика публикует событие, а получившие его
+// другие реплики удаляют заказ у себя, чтобы при следующем чтении загрузить его из БД.
+// Загрузка при промахе (GetOrLoad), опережающая перезагрузка и SetLocal событий не публикуют:
+// они кладут в кэш то, что уже есть в БД. Для общего кэша (RedisOrderCache) не нужен
+type InvalidatingOrderCache struct {
+	OrderCache
+
+	broker  Broker
+	subject string
+	origin  string
+
+	mu          sync.Mutex
+	unsubscribe func() error
+}
+
+// NewInvalidatingOrderCache - подписка кэша cache на события других реплик в subject брокера broker
+func NewInvalidatingOrderCache(cache OrderCache, broker Broker, subject string) (*InvalidatingOrderCache, error) {
+	c := &InvalidatingOrderCache{
+		OrderCache: cache,
+		broker:     broker,
+		subject:    subject,
+		origin:     fmt.Sprintf("%x", rand.Uint64()),
+	}
+	unsubscribe, err := broker.Subscribe(subject, c.apply)
+	if err != nil {
+		return nil, fmt.Errorf("Subscribe to cache invalidations failed: %v", err)
+	}
+	c.unsubscribe = unsubscribe
+	return c, nil
+}
+
+// apply - обработка события другой реплики: заказ удаляется из кэша без повторной публикации
+func (c *InvalidatingOrderCache) apply(data []byte) {
+	var inv invalidation
+	if err := json.Unmarshal(data, &inv); err != nil {
+		fmt.Println(time.Now(), "bad cache invalidation:", err)
+		return
+	}
+	if inv.Origin == c.origin {
+		return
+	}
+	err := c.OrderCache.Delete(inv.UID)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		fmt.Println(time.Now(), inv.UID, "cache invalidation failed:", err)
+	}
+}
+
+// publish - рассылка события об изменении заказа uid. Ошибка публикации не отменяет изменение,
+// другие реплики в этом случае увидят новый заказ по истечении времени жизни старого
+func (c *InvalidatingOrderCache) publish(uid string) {
+	data, err := json.Marshal(invalidation{Origin: c.origin, UID: uid})
+	if err == nil {
+		err = c.broker.Publish(c.subject, data)
+	}
+	if err != nil {
+		fmt.Println(time.Now(), uid, "publishing cache invalidation failed:", err)
+	}
+}
+
+// Set - запись заказа с перезаписью и оповещением других реплик
+func (c *InvalidatingOrderCache) Set(uid string, o Order, duration time.Duration) error {
+	err := c.OrderCache.Set(uid, o, duration)
+	if err == nil {
+		c.publish(uid)
+	}
+	return err
+}
+
+// SetWithPolicy - запись заказа с собственным правилом истечения и оповещением других реплик
+func (c *InvalidatingOrderCache) SetWithPolicy(uid string, o Order, p ExpirationPolicy) error {
+	err := c.OrderCache.SetWithPolicy(uid, o, p)
+	if err == nil {
+		c.publish(uid)
+	}
+	return err
+}
+
+// SetLocal - запись заказа без оповещения других реплик. Для заполнения кэша заказами из БД
+// (прогрев), которые не изменились и не должны вытеснять заказ из кэшей других реплик
+func (c *InvalidatingOrderCache) SetLocal(uid string, o Order, duration time.Duration) error {
+	return c.OrderCache.Set(uid, o, duration)
+}
+
+// Delete - удаление заказа с оповещением других реплик, которые могут хранить его, даже если здесь его нет
+func (c *InvalidatingOrderCache) Delete(uid string) error {
+	err := c.OrderCache.Delete(uid)
+	if err == nil || errors.Is(err, ErrKeyNotFound) {
+		c.publish(uid)
+	}
+	return err
+}
+
+// Lookup - поиск по вторичным индексам вложенного кэша, если они у него есть
+func (c *InvalidatingOrderCache) Lookup(f IndexField, value string) []Order {
+	if index, ok := c.OrderCache.(OrderIndex); ok {
+		return index.Lookup(f, value)
+	}
+	return nil
+}
+
+// Close - отписка от событий других реплик и закрытие вложенного кэша
+func (c *InvalidatingOrderCache) Close() error {
+	c.mu.Lock()
+	unsubscribe := c.unsubscribe
+	c.unsubscribe = nil
+	c.mu.Unlock()
+
+	if unsubscribe != nil {
+		if err := unsubscribe(); err != nil {
+			fmt.Println(time.Now(), "unsubscribing from cache invalidations failed:", err)
+		}
+	}
+	return c.OrderCache.Close()
+}
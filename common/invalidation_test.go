@@ -0,0 +1,117 @@
+package common
+
+import (
+	"GoProjectL0/common/brokertest"
+	"context"
+	"testing"
+	"time"
+)
+
+const testInvalidationSubject = "orders.invalidate"
+
+// newTestReplicas - n кэшей в памяти, согласованных через общий брокер
+func newTestReplicas(t *testing.T, n int) ([]*InvalidatingOrderCache, *brokertest.Broker) {
+	t.Helper()
+	broker := brokertest.NewBroker()
+	replicas := make([]*InvalidatingOrderCache, n)
+	for i := range replicas {
+		c, err := NewInvalidatingOrderCache(NewMemoryOrderCache(CacheConfig{DefaultExpiration: time.Hour}), broker, testInvalidationSubject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas[i] = c
+	}
+	t.Cleanup(func() {
+		for _, c := range replicas {
+			c.Close()
+		}
+		broker.Close()
+	})
+	return replicas, broker
+}
+
+func TestInvalidatingOrderCacheReplicas(t *testing.T) {
+	tests := []struct {
+		name string
+		// change - изменение заказа o на первой реплике
+		change func(c *InvalidatingOrderCache, o Order) error
+		// wantFirst - остается ли заказ в кэше первой реплики
+		wantFirst bool
+		// wantSecond - остается ли заказ в кэше второй реплики
+		wantSecond bool
+	}{
+		{name: "set", change: func(c *InvalidatingOrderCache, o Order) error {
+			o.TrackNumber = "changed"
+			return c.Set(o.OrderUID, o, 0)
+		}, wantFirst: true, wantSecond: false},
+		{name: "set with policy", change: func(c *InvalidatingOrderCache, o Order) error {
+			return c.SetWithPolicy(o.OrderUID, o, ExpirationPolicy{Mode: ExpireSliding})
+		}, wantFirst: true, wantSecond: false},
+		{name: "delete", change: func(c *InvalidatingOrderCache, o Order) error {
+			return c.Delete(o.OrderUID)
+		}, wantFirst: false, wantSecond: false},
+		{name: "set local", change: func(c *InvalidatingOrderCache, o Order) error {
+			return c.SetLocal(o.OrderUID, o, 0)
+		}, wantFirst: true, wantSecond: true},
+		{name: "get or load", change: func(c *InvalidatingOrderCache, o Order) error {
+			c.OrderCache.Delete(o.OrderUID)
+			_, err := c.GetOrLoad(o.OrderUID, func(string) (Order, error) { return o, nil })
+			return err
+		}, wantFirst: true, wantSecond: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replicas, broker := newTestReplicas(t, 2)
+			first, second := replicas[0], replicas[1]
+			o := *NewOrderGen()
+			for _, c := range replicas {
+				if err := c.SetLocal(o.OrderUID, o, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := tt.change(first, o); err != nil {
+				t.Fatalf("change: %v", err)
+			}
+			broker.Flush()
+
+			if _, found := first.Peek(o.OrderUID); found != tt.wantFirst {
+				t.Fatalf("first replica has order = %v, want %v", found, tt.wantFirst)
+			}
+			if _, found := second.Peek(o.OrderUID); found != tt.wantSecond {
+				t.Fatalf("second replica has order = %v, want %v", found, tt.wantSecond)
+			}
+		})
+	}
+}
+
+// Прогрев одной реплики не вытесняет заказы из кэшей других
+func TestInvalidatingOrderCacheWarmup(t *testing.T) {
+	replicas, broker := newTestReplicas(t, 2)
+	store := NewMemoryOrderStore()
+	var orders []Order
+	for i := 0; i < 4; i++ {
+		o := *NewOrderGen()
+		if err := store.Save(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+		orders = append(orders, o)
+		if err := replicas[1].SetLocal(o.OrderUID, o, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := NewAll(Connector{}, replicas[0])
+	defer a.Close()
+	a.Store = store
+	if err := a.UploadCache(context.Background(), WarmupConfig{PageSize: 2, BatchSize: 2, Workers: 2}); err != nil {
+		t.Fatalf("UploadCache: %v", err)
+	}
+	broker.Flush()
+
+	for i, c := range replicas {
+		if n := c.Len(); n != len(orders) {
+			t.Fatalf("replica %d Len = %d after warmup, want %d", i, n, len(orders))
+		}
+	}
+}
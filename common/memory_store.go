@@ -0,0 +1,165 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryOrderStore - хранилище заказов в памяти процесса. Позволяет работать с All без Postgres,
+// например в тестах обработчиков. Заказы хранятся копиями, поэтому изменение сохраненного
+// или прочитанного заказа вызывающим кодом хранилище не затрагивает
+type MemoryOrderStore struct {
+	mu     sync.RWMutex
+	orders map[string]Order
+	hashes map[string][]byte
+}
+
+// NewMemoryOrderStore - создание пустого хранилища
+func NewMemoryOrderStore() *MemoryOrderStore {
+	return &MemoryOrderStore{orders: make(map[string]Order), hashes: make(map[string][]byte)}
+}
+
+// Save - сохранение нового заказа, повтор OrderUID - ErrOrderDuplicate или ErrOrderExists
+func (s *MemoryOrderStore) Save(ctx context.Context, o Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if o.OrderUID == "" {
+		return fmt.Errorf("%w: order_uid is empty", ErrOrderRejected)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := orderHash(o)
+	if saved, ok := s.hashes[o.OrderUID]; ok {
+		if bytes.Equal(saved, hash) {
+			return ErrOrderDuplicate
+		}
+		return ErrOrderExists
+	}
+	s.orders[o.OrderUID] = copyOrder(o)
+	s.hashes[o.OrderUID] = hash
+	return nil
+}
+
+// SaveMany - сохранение заказов по одному в порядке пачки
+func (s *MemoryOrderStore) SaveMany(ctx context.Context, orders []Order) []error {
+	errs := make([]error, len(orders))
+	for i, o := range orders {
+		errs[i] = s.Save(ctx, o)
+	}
+	return errs
+}
+
+// Get - чтение заказа, если его нет - ErrOrderNotFound
+func (s *MemoryOrderStore) Get(ctx context.Context, uid string) (Order, error) {
+	if err := ctx.Err(); err != nil {
+		return Order{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[uid]
+	if !ok {
+		return Order{OrderUID: uid}, ErrOrderNotFound
+	}
+	return copyOrder(o), nil
+}
+
+// GetMany - чтение нескольких заказов, отсутствующих заказов в результате нет
+func (s *MemoryOrderStore) GetMany(ctx context.Context, uids []string) (map[string]Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := make(map[string]Order, len(uids))
+	for _, uid := range uids {
+		if o, ok := s.orders[uid]; ok {
+			orders[uid] = copyOrder(o)
+		}
+	}
+	return orders, nil
+}
+
+// List - страница OrderUID от новых заказов к старым, курсор - OrderUID последнего заказа страницы
+func (s *MemoryOrderStore) List(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	s.mu.RLock()
+	all := make([]Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		all = append(all, o)
+	}
+	s.mu.RUnlock()
+
+	// Тот же порядок, что у PgOrderStore: по дате создания, при равных датах - по OrderUID, оба по убыванию.
+	// Нулевая дата соответствует заказу без даты и идет последней
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].DateCreated.Equal(all[j].DateCreated) {
+			return all[i].DateCreated.After(all[j].DateCreated)
+		}
+		return all[i].OrderUID > all[j].OrderUID
+	})
+
+	start := 0
+	if cursor != "" {
+		start = len(all)
+		for i, o := range all {
+			if o.OrderUID == cursor {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	uids := make([]string, 0, limit)
+	for i := start; i < len(all) && len(uids) < limit; i++ {
+		uids = append(uids, all[i].OrderUID)
+	}
+	if len(uids) < limit || start+len(uids) == len(all) {
+		return uids, "", nil
+	}
+	return uids, uids[len(uids)-1], nil
+}
+
+// Delete - удаление заказа, если его нет - ErrOrderNotFound
+func (s *MemoryOrderStore) Delete(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[uid]; !ok {
+		return ErrOrderNotFound
+	}
+	delete(s.orders, uid)
+	delete(s.hashes, uid)
+	return nil
+}
+
+// Exists - есть ли заказ в хранилище
+func (s *MemoryOrderStore) Exists(ctx context.Context, uid string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.orders[uid]
+	return ok, nil
+}
+
+// copyOrder - копия заказа с собственным списком товаров
+func copyOrder(o Order) Order {
+	if o.Items != nil {
+		o.Items = append([]Item(nil), o.Items...)
+	}
+	return o
+}
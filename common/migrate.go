@@ -0,0 +1,248 @@
+package common
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles - файлы миграций схемы вида NNNN_name.up.sql и NNNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey - ключ advisory lock Postgres, под которым применяются миграции,
+// чтобы несколько одновременно запущенных клиентов не применяли их параллельно
+const migrationLockKey = 7343200125
+
+// Migration - версия схемы БД: SQL перехода на нее (Up) и отката с нее на предыдущую (Down)
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations - встроенные миграции в порядке возрастания версий
+func Migrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		name := f.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("Migration %s: expected .up.sql or .down.sql suffix", name)
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, title, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("Migration %s: expected NNNN_name prefix", name)
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		} else if m.Name != title {
+			return nil, fmt.Errorf("Migration %d has two names: %s and %s", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("Migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate - применение всех еще не примененных миграций, каждой в своей транзакции.
+// Возвращает количество примененных миграций. Если в БД есть версии новее встроенных
+// (ее уже обновил более новый клиент), ничего не откатывает и только сообщает об этом
+func Migrate(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	err = withMigrationLock(ctx, pool, func(conn *pgx.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		known := make(map[int]bool, len(migrations))
+		for _, m := range migrations {
+			known[m.Version] = true
+			if versions[m.Version] {
+				continue
+			}
+			err := inTx(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "insert into schema_migrations (version, name) values ($1, $2)", m.Version, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Migration %d_%s failed: %w", m.Version, m.Name, err)
+			}
+			fmt.Println(time.Now(), "migration applied:", m.Version, m.Name)
+			applied++
+		}
+		for v := range versions {
+			if !known[v] {
+				fmt.Println(time.Now(), "database has unknown migration", v, "- client is older than schema")
+			}
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown - откат примененных миграций с версиями больше target, от новых к старым,
+// каждой в своей транзакции. Возвращает количество откаченных миграций
+func MigrateDown(ctx context.Context, pool *pgxpool.Pool, target int) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	reverted := 0
+	err = withMigrationLock(ctx, pool, func(conn *pgx.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version <= target || !versions[m.Version] {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("Migration %d_%s has no down script", m.Version, m.Name)
+			}
+			err := inTx(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "delete from schema_migrations where version = $1", m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Migration %d_%s rollback failed: %w", m.Version, m.Name, err)
+			}
+			fmt.Println(time.Now(), "migration reverted:", m.Version, m.Name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationVersion - последняя примененная версия схемы, 0 - миграции не применялись
+func MigrationVersion(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	var version int
+	err := pool.QueryRow(ctx, "select coalesce(max(version), 0) from schema_migrations").Scan(&version)
+	if err != nil {
+		// Таблицы еще нет - ни одна миграция не применялась
+		if exists, e := tableExists(ctx, pool, "schema_migrations"); e == nil && !exists {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// withMigrationLock - выполнение f на отдельном соединении под advisory lock миграций.
+// Блокировка принадлежит соединению, поэтому берется и снимается на одном и том же соединении
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, f func(conn *pgx.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Acquire connection failed: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "select pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("Migration lock failed: %w", err)
+	}
+	// Контекст мог быть отменен, а блокировку снять нужно в любом случае
+	defer conn.Exec(context.Background(), "select pg_advisory_unlock($1)", migrationLockKey)
+
+	_, err = conn.Exec(ctx, `create table if not exists schema_migrations (
+		version bigint primary key,
+		name text not null,
+		applied_at timestamptz not null default now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("Create schema_migrations failed: %w", err)
+	}
+	return f(conn.Conn())
+}
+
+// appliedVersions - версии миграций, записанные в schema_migrations
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int]bool, error) {
+	rows, err := conn.Query(ctx, "select version from schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions[v] = true
+	}
+	return versions, rows.Err()
+}
+
+// inTx - выполнение f в транзакции на соединении conn, при ошибке транзакция откатывается
+func inTx(ctx context.Context, conn *pgx.Conn, f func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// tableExists - есть ли в текущей схеме таблица name
+func tableExists(ctx context.Context, pool *pgxpool.Pool, name string) (bool, error) {
+	var exists bool
+	err := pool.QueryRow(ctx, "select to_regclass($1) is not null", name).Scan(&exists)
+	return exists, err
+}
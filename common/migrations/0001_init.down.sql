@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS item;
+DROP TABLE IF EXISTS payment;
+DROP TABLE IF EXISTS delivery;
@@ -1,4 +1,4 @@
-CREATE TABLE delivery
+CREATE TABLE IF NOT EXISTS delivery
 (
     del_id      uuid primary key default gen_random_uuid(),
     del_name    VARCHAR(50),
@@ -10,7 +10,7 @@ CREATE TABLE delivery
     Email   VARCHAR (50)
 );
 
-CREATE TABLE payment
+CREATE TABLE IF NOT EXISTS payment
 (
     pay_id uuid primary key default gen_random_uuid(),
     Transaction VARCHAR (50),
@@ -25,7 +25,7 @@ CREATE TABLE payment
     CustomFee bigint
 );
 
-CREATE TABLE  item
+CREATE TABLE IF NOT EXISTS item
 (
     ChrtID BIGINT NOT NULL primary key,
     TrackNumber VARCHAR (50),
@@ -42,7 +42,7 @@ CREATE TABLE  item
 );
 
 
-CREATE TABLE orders
+CREATE TABLE IF NOT EXISTS orders
 (
     OrderUID VARCHAR(50) not null PRIMARY KEY ,
     TrackNumber varchar(50),
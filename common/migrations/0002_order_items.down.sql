@@ -0,0 +1,29 @@
+-- Возврат к глобальному ключу ChrtID: из товаров с одинаковым ChrtID остается первый,
+-- но orders.Items сохраняет ссылки на все товары заказа
+ALTER TABLE orders ADD COLUMN Items bigint[];
+UPDATE orders o SET Items = (SELECT array_agg(i.ChrtID ORDER BY i.Position) FROM item i WHERE i.orderid = o.OrderUID);
+
+CREATE TABLE chrt_item
+(
+    ChrtID BIGINT NOT NULL primary key,
+    TrackNumber VARCHAR (50),
+    Price BIGINT,
+    Rid VARCHAR (50),
+    Item_name VARCHAR (50),
+    Sale bigint,
+    Size VARCHAR (50),
+    TotalPrice bigint,
+    NmID bigint,
+    Brand VARCHAR (50),
+    Status bigint,
+    orderid VARCHAR (50)
+);
+
+INSERT INTO chrt_item (ChrtID, TrackNumber, Price, Rid, Item_name, Sale, Size, TotalPrice, NmID, Brand, Status, orderid)
+SELECT DISTINCT ON (ChrtID) ChrtID, TrackNumber, Price, Rid, Item_name, Sale, Size, TotalPrice, NmID, Brand, Status, orderid
+FROM item
+ORDER BY ChrtID, orderid, Position;
+
+DROP TABLE item;
+ALTER TABLE chrt_item RENAME TO item;
+ALTER TABLE item RENAME CONSTRAINT chrt_item_pkey TO item_pkey;
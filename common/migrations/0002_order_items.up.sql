@@ -0,0 +1,34 @@
+-- Товары принадлежат заказу: ключ - заказ и позиция товара в нем, поэтому один chrt_id
+-- может встречаться в разных заказах и несколько раз в одном
+CREATE TABLE order_item
+(
+    orderid VARCHAR(50) NOT NULL REFERENCES orders (OrderUID) ON DELETE CASCADE,
+    Position integer NOT NULL,
+    ChrtID BIGINT NOT NULL,
+    TrackNumber VARCHAR (50),
+    Price BIGINT,
+    Rid VARCHAR (50),
+    Item_name VARCHAR (50),
+    Sale bigint,
+    Size VARCHAR (50),
+    TotalPrice bigint,
+    NmID bigint,
+    Brand VARCHAR (50),
+    Status bigint,
+    PRIMARY KEY (orderid, Position)
+);
+
+-- Переносим товары в порядке orders.Items. Раньше заказ получал товар по ChrtID, даже если строку
+-- записал другой заказ, поэтому каждый заказ получает собственную копию тех же данных, что и отдавались.
+-- Товары, на которые не ссылается ни один заказ, не переносятся
+INSERT INTO order_item (orderid, Position, ChrtID, TrackNumber, Price, Rid, Item_name, Sale, Size, TotalPrice, NmID, Brand, Status)
+SELECT o.OrderUID, a.pos - 1, i.ChrtID, i.TrackNumber, i.Price, i.Rid, i.Item_name, i.Sale, i.Size, i.TotalPrice, i.NmID, i.Brand, i.Status
+FROM orders o
+    CROSS JOIN LATERAL unnest(o.Items) WITH ORDINALITY AS a (chrt, pos)
+    JOIN item i ON i.ChrtID = a.chrt;
+
+DROP TABLE item;
+ALTER TABLE order_item RENAME TO item;
+ALTER TABLE item RENAME CONSTRAINT order_item_pkey TO item_pkey;
+ALTER TABLE item RENAME CONSTRAINT order_item_orderid_fkey TO item_orderid_fkey;
+ALTER TABLE orders DROP COLUMN Items;
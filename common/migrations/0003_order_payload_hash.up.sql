@@ -0,0 +1,3 @@
+-- Хэш содержимого сохраненного заказа: повторно доставленный заказ с тем же хэшем - дубликат,
+-- с другим - конфликт. У заказов, сохраненных до этой миграции, хэша нет
+ALTER TABLE orders ADD COLUMN PayloadHash bytea;
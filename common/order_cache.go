@@ -0,0 +1,54 @@
+package common
+
+import "time"
+
+// OrderCache - кэш заказов по OrderUID, от которого зависит All.
+// Реализации: MemoryOrderCache (в памяти процесса) и RedisOrderCache (общий для нескольких реплик)
+type OrderCache interface {
+	// Get - получение заказа из кэша
+	Get(uid string) (Order, bool)
+	// Peek - получение заказа без учета обращения в статистике, вытеснении и продлении времени жизни
+	Peek(uid string) (Order, bool)
+	// Set - запись заказа с перезаписью, duration 0 - время жизни по умолчанию
+	Set(uid string, o Order, duration time.Duration) error
+	// SetWithPolicy - запись заказа с перезаписью и собственным правилом истечения
+	SetWithPolicy(uid string, o Order, p ExpirationPolicy) error
+	// SetRefresher - функция перезагрузки заказов с опережающим обновлением
+	SetRefresher(refresher func(string) (Order, error))
+	// Delete - удаление заказа, если его нет - ErrKeyNotFound
+	Delete(uid string) error
+	// GetOrLoad - получение заказа, а при промахе - загрузка через loader и запись в кэш
+	GetOrLoad(uid string, loader func(string) (Order, error)) (Order, error)
+	// GetOrLoadEncoded - то же, что GetOrLoad, но вместе с JSON заказа, если кэш его хранит, иначе nil
+	GetOrLoadEncoded(uid string, loader func(string) (Order, error)) (Order, *Encoded, error)
+	// Len - количество заказов в кэше
+	Len() int
+	// MaxEntries - лимит количества заказов, 0 - без ограничения
+	MaxEntries() int
+	// Stats - статистика работы кэша
+	Stats() CacheStats
+	// Close - освобождение ресурсов кэша
+	Close() error
+}
+
+// Snapshotter - кэш, умеющий сохранять свое содержимое в файл и восстанавливать из него
+type Snapshotter interface {
+	SaveFile(path string) error
+	LoadFile(path string) (int, error)
+}
+
+// MemoryOrderCache - кэш заказов в памяти процесса на основе Cache
+type MemoryOrderCache struct {
+	*Cache[string, Order]
+}
+
+// NewMemoryOrderCache - создание кэша заказов в памяти с параметрами cfg
+func NewMemoryOrderCache(cfg CacheConfig) *MemoryOrderCache {
+	return &MemoryOrderCache{NewCacheWithConfig[string, Order](cfg)}
+}
+
+// Set - запись заказа с перезаписью, ErrTooLarge - заказ больше лимита MaxBytes
+func (m *MemoryOrderCache) Set(uid string, o Order, duration time.Duration) error {
+	_, err := m.write(uid, o, duration, nil)
+	return err
+}
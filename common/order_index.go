@@ -0,0 +1,279 @@
+package common
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IndexField - поле заказа, по которому строится вторичный индекс кэша
+type IndexField int
+
+const (
+	// IndexTrackNumber - Order.TrackNumber
+	IndexTrackNumber IndexField = iota
+	// IndexCustomer - Order.CustomerID
+	IndexCustomer
+	// IndexTransaction - Order.Pays.Transaction
+	IndexTransaction
+	// IndexChrtID - Order.Items[].ChrtID, у заказа может быть несколько значений
+	IndexChrtID
+
+	indexFieldCount
+)
+
+// String - название поля, совпадающее с его именем в JSON заказа
+func (f IndexField) String() string {
+	switch f {
+	case IndexTrackNumber:
+		return "track_number"
+	case IndexCustomer:
+		return "customer_id"
+	case IndexTransaction:
+		return "transaction"
+	case IndexChrtID:
+		return "chrt_id"
+	}
+	return "unknown"
+}
+
+// OrderIndex - кэш заказов с поиском по вторичным индексам
+type OrderIndex interface {
+	// Lookup - заказы из кэша, у которых поле f равно value
+	Lookup(f IndexField, value string) []Order
+}
+
+// orderIndexKeys - значения индексируемых полей одного заказа
+type orderIndexKeys [indexFieldCount][]string
+
+// indexKeys - значения индексируемых полей заказа o, пустые значения не индексируются
+func indexKeys(o Order) orderIndexKeys {
+	var keys orderIndexKeys
+	if o.TrackNumber != "" {
+		keys[IndexTrackNumber] = []string{o.TrackNumber}
+	}
+	if o.CustomerID != "" {
+		keys[IndexCustomer] = []string{o.CustomerID}
+	}
+	if o.Pays.Transaction != "" {
+		keys[IndexTransaction] = []string{o.Pays.Transaction}
+	}
+	for _, i := range o.Items {
+		keys[IndexChrtID] = append(keys[IndexChrtID], strconv.Itoa(i.ChrtID))
+	}
+	return keys
+}
+
+// matches - есть ли у заказа o значение value в поле f
+func (keys orderIndexKeys) matches(f IndexField, value string) bool {
+	for _, v := range keys[f] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// observableOrderCache - кэш, сообщающий о добавлении и удалении заказов (MemoryOrderCache).
+// Индекс такого кэша обновляется по его событиям, в том числе при вытеснении, истечении и загрузке снимка
+type observableOrderCache interface {
+	OnInserted(f func(uid string, o Order))
+	OnEvicted(f func(uid string, o Order, reason EvictionReason))
+	Peek(uid string) (Order, bool)
+	Range(f func(uid string, o Order) bool)
+}
+
+// IndexedOrderCache - кэш заказов со вторичными индексами по полям IndexField.
+// Индекс ссылается на OrderUID, сами заказы хранит вложенный кэш.
+// Если вложенный кэш не сообщает об удалениях (RedisOrderCache), индекс содержит только заказы,
+// прошедшие через эту реплику, а устаревшие ссылки отбрасываются при поиске
+type IndexedOrderCache struct {
+	OrderCache
+
+	// observed - индекс обновляется по событиям вложенного кэша
+	observed bool
+	peek     func(uid string) (Order, bool)
+
+	mu      sync.RWMutex
+	byValue [indexFieldCount]map[string]map[string]struct{}
+	byUID   map[string]orderIndexKeys
+}
+
+// NewIndexedOrderCache - индексирование кэша cache, заказы, которые в нем уже есть, индексируются сразу
+func NewIndexedOrderCache(cache OrderCache) *IndexedOrderCache {
+	x := &IndexedOrderCache{OrderCache: cache, byUID: make(map[string]orderIndexKeys)}
+	for f := range x.byValue {
+		x.byValue[f] = make(map[string]map[string]struct{})
+	}
+
+	if obs, ok := cache.(observableOrderCache); ok {
+		x.observed = true
+		x.peek = obs.Peek
+		// События приходят вне блокировок кэша и могут обгонять друг друга,
+		// поэтому индекс сверяется с текущим содержимым кэша, а не со значением из события
+		obs.OnInserted(func(uid string, _ Order) {
+			x.resync(uid)
+		})
+		obs.OnEvicted(func(uid string, _ Order, _ EvictionReason) {
+			x.resync(uid)
+		})
+		obs.Range(func(uid string, o Order) bool {
+			x.index(uid, o)
+			return true
+		})
+	}
+	return x
+}
+
+// resync - приведение индекса заказа uid к его текущему значению во вложенном кэше
+func (x *IndexedOrderCache) resync(uid string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if o, found := x.peek(uid); found {
+		x.indexLocked(uid, o)
+	} else {
+		x.unindexLocked(uid)
+	}
+}
+
+// index - индексирование заказа o с заменой прежних значений его полей
+func (x *IndexedOrderCache) index(uid string, o Order) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.indexLocked(uid, o)
+}
+
+func (x *IndexedOrderCache) indexLocked(uid string, o Order) {
+	x.unindexLocked(uid)
+	keys := indexKeys(o)
+	for f, values := range keys {
+		for _, v := range values {
+			uids, ok := x.byValue[f][v]
+			if !ok {
+				uids = make(map[string]struct{})
+				x.byValue[f][v] = uids
+			}
+			uids[uid] = struct{}{}
+		}
+	}
+	x.byUID[uid] = keys
+}
+
+// unindex - удаление заказа uid из индекса
+func (x *IndexedOrderCache) unindex(uid string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.unindexLocked(uid)
+}
+
+func (x *IndexedOrderCache) unindexLocked(uid string) {
+	keys, ok := x.byUID[uid]
+	if !ok {
+		return
+	}
+	for f, values := range keys {
+		for _, v := range values {
+			uids := x.byValue[f][v]
+			delete(uids, uid)
+			if len(uids) == 0 {
+				delete(x.byValue[f], v)
+			}
+		}
+	}
+	delete(x.byUID, uid)
+}
+
+// Get - получение заказа из кэша
+func (x *IndexedOrderCache) Get(uid string) (Order, bool) {
+	o, found := x.OrderCache.Get(uid)
+	if !x.observed {
+		if found {
+			x.index(uid, o)
+		} else {
+			x.unindex(uid)
+		}
+	}
+	return o, found
+}
+
+// Set - запись заказа с перезаписью, duration 0 - время жизни по умолчанию
+func (x *IndexedOrderCache) Set(uid string, o Order, duration time.Duration) error {
+	err := x.OrderCache.Set(uid, o, duration)
+	if err == nil && !x.observed {
+		x.index(uid, o)
+	}
+	return err
+}
+
+// SetWithPolicy - запись заказа с перезаписью и собственным правилом истечения
+func (x *IndexedOrderCache) SetWithPolicy(uid string, o Order, p ExpirationPolicy) error {
+	err := x.OrderCache.SetWithPolicy(uid, o, p)
+	if err == nil && !x.observed {
+		x.index(uid, o)
+	}
+	return err
+}
+
+// Delete - удаление заказа, если его нет - ErrKeyNotFound
+func (x *IndexedOrderCache) Delete(uid string) error {
+	err := x.OrderCache.Delete(uid)
+	if !x.observed {
+		x.unindex(uid)
+	}
+	return err
+}
+
+// GetOrLoad - получение заказа, а при промахе - загрузка через loader и запись в кэш
+func (x *IndexedOrderCache) GetOrLoad(uid string, loader func(string) (Order, error)) (Order, error) {
+	o, err := x.OrderCache.GetOrLoad(uid, loader)
+	if err == nil && !x.observed {
+		x.index(uid, o)
+	}
+	return o, err
+}
+
+// GetOrLoadEncoded - получение заказа вместе с JSON, а при промахе - загрузка через loader и запись в кэш
+func (x *IndexedOrderCache) GetOrLoadEncoded(uid string, loader func(string) (Order, error)) (Order, *Encoded, error) {
+	o, encoded, err := x.OrderCache.GetOrLoadEncoded(uid, loader)
+	if err == nil && !x.observed {
+		x.index(uid, o)
+	}
+	return o, encoded, err
+}
+
+// Lookup - заказы из кэша, у которых поле f равно value, от новых к старым.
+// Ссылки индекса проверяются по текущим значениям заказов в кэше
+func (x *IndexedOrderCache) Lookup(f IndexField, value string) []Order {
+	if f < 0 || f >= indexFieldCount {
+		return nil
+	}
+
+	x.mu.RLock()
+	uids := make([]string, 0, len(x.byValue[f][value]))
+	for uid := range x.byValue[f][value] {
+		uids = append(uids, uid)
+	}
+	x.mu.RUnlock()
+
+	// Поиск не считается чтением заказов: не меняет статистику, порядок вытеснения и время жизни.
+	// Без событий вложенного кэша ссылки проверяются обычным чтением
+	get := x.peek
+	if get == nil {
+		get = x.Get
+	}
+	orders := make([]Order, 0, len(uids))
+	for _, uid := range uids {
+		o, found := get(uid)
+		if found && indexKeys(o).matches(f, value) {
+			orders = append(orders, o)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].DateCreated.After(orders[j].DateCreated)
+	})
+	return orders
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// Поиск по индексу не считается чтением: не меняет статистику и не продвигает заказ в очереди LRU
+func TestIndexedOrderCacheLookupIsNotRead(t *testing.T) {
+	cache := NewMemoryOrderCache(CacheConfig{DefaultExpiration: time.Hour, MaxEntries: 2, Shards: 1, Policy: PolicyLRU})
+	defer cache.Close()
+	x := NewIndexedOrderCache(cache)
+	first, second, third := *NewOrderGen(), *NewOrderGen(), *NewOrderGen()
+	for _, o := range []Order{first, second} {
+		if err := x.Set(o.OrderUID, o, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if orders := x.Lookup(IndexTrackNumber, first.TrackNumber); len(orders) != 1 || orders[0].OrderUID != first.OrderUID {
+		t.Fatalf("Lookup = %+v, want [%s]", orders, first.OrderUID)
+	}
+	if stats := x.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Stats hits/misses = %d/%d after Lookup, want 0/0", stats.Hits, stats.Misses)
+	}
+
+	// Первый заказ остался самым давним и вытесняется первым
+	if err := x.Set(third.OrderUID, third, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := x.Peek(first.OrderUID); found {
+		t.Fatal("looked up order was not evicted first")
+	}
+	if orders := x.Lookup(IndexTrackNumber, first.TrackNumber); len(orders) != 0 {
+		t.Fatalf("Lookup of evicted order = %+v, want none", orders)
+	}
+}
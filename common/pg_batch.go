@@ -0,0 +1,203 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// SaveMany - сохранение пачки новых заказов в одной транзакции. Результат для каждого заказа - в ошибке
+// с тем же индексом, значения как у Save. Повтор OrderUID внутри пачки обрабатывается так же, как повтор
+// уже сохраненного заказа. Новые заказы записываются COPY, а если это не удалось из-за какого-то
+// из них - по одному, каждый в своей точке сохранения, чтобы некорректный заказ не мешал остальным
+func (s *PgOrderStore) SaveMany(ctx context.Context, orders []Order) []error {
+	errs := make([]error, len(orders))
+	if len(orders) == 0 {
+		return errs
+	}
+	if err := s.saveMany(ctx, orders, errs); err != nil {
+		// Транзакция не зафиксирована: заказы, которые должны были сохраниться, не сохранены
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// saveMany - запись пачки заказов, ошибки отдельных заказов записываются в errs,
+// возвращаемая ошибка относится ко всей транзакции
+func (s *PgOrderStore) saveMany(ctx context.Context, orders []Order, errs []error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Begin transaction failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	uids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		uids = append(uids, o.OrderUID)
+	}
+	// Блокировки берутся в одном порядке, поэтому пачки с общими заказами не блокируют друг друга навсегда
+	query := `select count(pg_advisory_xact_lock(k)) from (select distinct hashtext(u) as k from unnest($1::text[]) as u order by k) as keys`
+	if _, err = tx.Exec(ctx, query, uids); err != nil {
+		return fmt.Errorf("Order lock failed: %w", err)
+	}
+	saved, err := savedHashes(ctx, tx, uids)
+	if err != nil {
+		return err
+	}
+
+	// Первый заказ с этим OrderUID в пачке считается сохраненным, следующие сравниваются с ним
+	inBatch := make(map[string][]byte, len(saved)+len(orders))
+	for uid, h := range saved {
+		inBatch[uid] = h
+	}
+	pending := make([]int, 0, len(orders))
+	hashes := make([][]byte, len(orders))
+	for i, o := range orders {
+		hashes[i] = orderHash(o)
+		if o.OrderUID == "" {
+			errs[i] = fmt.Errorf("%w: order_uid is empty", ErrOrderRejected)
+			continue
+		}
+		if errs[i] = savedError(inBatch, o.OrderUID, hashes[i]); errs[i] != nil {
+			continue
+		}
+		inBatch[o.OrderUID] = hashes[i]
+		pending = append(pending, i)
+	}
+
+	if err = copyOrdersSavepoint(ctx, tx, orders, hashes, pending); err != nil {
+		fmt.Println(time.Now(), "bulk insert of", len(pending), "orders failed, inserting one by one:", err)
+		// По одному в порядке пачки, как при последовательных Save: отвергнутый заказ не занимает OrderUID,
+		// поэтому следующий заказ пачки с тем же OrderUID записывается сам, а не получает чужую ошибку
+		for i, o := range orders {
+			if o.OrderUID == "" {
+				continue
+			}
+			if errs[i] = savedError(saved, o.OrderUID, hashes[i]); errs[i] != nil {
+				continue
+			}
+			if errs[i] = insertOrderSavepoint(ctx, tx, o, hashes[i]); errs[i] == nil {
+				saved[o.OrderUID] = hashes[i]
+			}
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Commit failed: %w", err)
+	}
+	return nil
+}
+
+// savedHashes - хэши содержимого уже сохраненных заказов из uids
+func savedHashes(ctx context.Context, tx pgx.Tx, uids []string) (map[string][]byte, error) {
+	rows, err := tx.Query(ctx, "select OrderUID, PayloadHash from orders where OrderUID = any($1)", uids)
+	if err != nil {
+		return nil, fmt.Errorf("Select from Order failed: %w", err)
+	}
+	defer rows.Close()
+
+	saved := make(map[string][]byte)
+	for rows.Next() {
+		var uid string
+		var hash []byte
+		if err = rows.Scan(&uid, &hash); err != nil {
+			return nil, fmt.Errorf("Scanning saved orders failed: %w", err)
+		}
+		saved[uid] = hash
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Select from Order failed: %w", err)
+	}
+	return saved, nil
+}
+
+// savedError - результат сохранения заказа OrderUID uid с хэшем содержимого hash, если заказ с этим OrderUID
+// уже есть в saved: ErrOrderDuplicate для того же содержимого, ErrOrderExists для другого, иначе nil
+func savedError(saved map[string][]byte, uid string, hash []byte) error {
+	h, ok := saved[uid]
+	switch {
+	case !ok:
+		return nil
+	case bytes.Equal(h, hash):
+		return ErrOrderDuplicate
+	default:
+		return ErrOrderExists
+	}
+}
+
+// copyOrdersSavepoint - запись заказов orders[pending] командами COPY в точке сохранения:
+// при ошибке откатывается только она, и транзакцией можно пользоваться дальше
+func copyOrdersSavepoint(ctx context.Context, tx pgx.Tx, orders []Order, hashes [][]byte, pending []int) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Savepoint failed: %w", err)
+	}
+	defer sp.Rollback(ctx)
+
+	if err = copyOrders(ctx, sp, orders, hashes, pending); err != nil {
+		return err
+	}
+	return sp.Commit(ctx)
+}
+
+// copyOrders - запись доставок, оплат, заказов и товаров orders[pending] четырьмя командами COPY
+func copyOrders(ctx context.Context, tx pgx.Tx, orders []Order, hashes [][]byte, pending []int) error {
+	deliveries := make([][]interface{}, 0, len(pending))
+	payments := make([][]interface{}, 0, len(pending))
+	rows := make([][]interface{}, 0, len(pending))
+	var items [][]interface{}
+	for _, n := range pending {
+		o := orders[n]
+		DelId, PayId := newUUID(), newUUID()
+		deliveries = append(deliveries, []interface{}{DelId, o.Deliveries.Name, o.Deliveries.Phone, o.Deliveries.Zip, o.Deliveries.City, o.Deliveries.Address, o.Deliveries.Region, o.Deliveries.Email})
+		payments = append(payments, []interface{}{PayId, o.Pays.Transaction, o.Pays.RequestID, o.Pays.Currency, o.Pays.Provider, o.Pays.Amount, o.Pays.PaymentDt, o.Pays.Bank, o.Pays.DeliveryCost, o.Pays.GoodsTotal, o.Pays.CustomFee})
+		rows = append(rows, []interface{}{o.OrderUID, o.TrackNumber, o.Entry, DelId, PayId, o.Locale, o.InternalSignature, o.CustomerID, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard, hashes[n]})
+		for pos, i := range o.Items {
+			items = append(items, []interface{}{o.OrderUID, pos, i.ChrtID, i.TrackNumber, i.Price, i.Rid, i.Name, i.Sale, i.Size, i.TotalPrice, i.NmID, i.Brand, i.Status})
+		}
+	}
+
+	// COPY обращается к столбцам по точным именам, а без кавычек Postgres хранит их в нижнем регистре
+	copies := []struct {
+		table   string
+		columns []string
+		rows    [][]interface{}
+	}{
+		{"delivery", []string{"del_id", "del_name", "phone", "zip", "city", "address", "region", "email"}, deliveries},
+		{"payment", []string{"pay_id", "transaction", "requestid", "currency", "provider", "amount", "paymentdt", "bank", "deliverycost", "goodstotal", "customfee"}, payments},
+		{"orders", []string{"orderuid", "tracknumber", "entry", "deliveries", "pays", "locale", "internalsignature", "customerid", "deliveryservice", "shardkey", "smid", "datecreated", "oofshard", "payloadhash"}, rows},
+		{"item", []string{"orderid", "position", "chrtid", "tracknumber", "price", "rid", "item_name", "sale", "size", "totalprice", "nmid", "brand", "status"}, items},
+	}
+	for _, c := range copies {
+		if len(c.rows) == 0 {
+			continue
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{c.table}, c.columns, pgx.CopyFromRows(c.rows)); err != nil {
+			return fmt.Errorf("Copy to %s failed: %w", c.table, err)
+		}
+	}
+	return nil
+}
+
+// insertOrderSavepoint - вставка одного заказа в своей точке сохранения, ошибка - в терминах OrderStore
+func insertOrderSavepoint(ctx context.Context, tx pgx.Tx, o Order, hash []byte) error {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Savepoint failed: %w", err)
+	}
+	defer sp.Rollback(ctx)
+
+	if err = insertOrder(ctx, sp, o, hash); err != nil {
+		return classifySaveError(err)
+	}
+	return sp.Commit(ctx)
+}
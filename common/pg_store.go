@@ -0,0 +1,277 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"strings"
+)
+
+// PgOrderStore - хранилище заказов в Postgres (схема - миграции в migrations)
+type PgOrderStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPgOrderStore - хранилище заказов поверх пула соединений pool
+func NewPgOrderStore(pool *pgxpool.Pool) *PgOrderStore {
+	return &PgOrderStore{pool: pool}
+}
+
+// Save - сохранение нового заказа в одной транзакции. Повтор OrderUID - ErrOrderDuplicate или ErrOrderExists,
+// данные, нарушающие ограничения схемы (классы ошибок 22 и 23), - ErrOrderRejected
+func (s *PgOrderStore) Save(ctx context.Context, o Order) error {
+	return classifySaveError(s.save(ctx, o))
+}
+
+// classifySaveError - ошибка записи заказа в терминах OrderStore: повтор OrderUID - ErrOrderExists,
+// нарушение ограничений схемы (классы ошибок 22 и 23) - ErrOrderRejected, остальные ошибки без изменений
+func classifySaveError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch {
+	case pgErr.Code == "23505" && pgErr.TableName == "orders":
+		return fmt.Errorf("%w: %v", ErrOrderExists, err)
+	case strings.HasPrefix(pgErr.Code, "22"), strings.HasPrefix(pgErr.Code, "23"):
+		return fmt.Errorf("%w: %v", ErrOrderRejected, err)
+	}
+	return err
+}
+
+// save - запись заказа со всеми связанными записями в одной транзакции: при любой ошибке
+// транзакция откатывается и в БД не остается ни доставки, ни оплаты, ни части товаров
+func (s *PgOrderStore) save(ctx context.Context, o Order) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Begin transaction failed: %w", err)
+	}
+	// После Commit откат ничего не делает
+	defer tx.Rollback(ctx)
+
+	// Одновременные сохранения одного и того же заказа выполняются по очереди, поэтому второе
+	// увидит первый заказ и не дойдет до вставки доставки и оплаты
+	if _, err = tx.Exec(ctx, "select pg_advisory_xact_lock(hashtext($1))", o.OrderUID); err != nil {
+		return fmt.Errorf("Order lock failed: %w", err)
+	}
+	hash := orderHash(o)
+	var saved []byte
+	err = tx.QueryRow(ctx, "select PayloadHash from orders where OrderUID = $1", o.OrderUID).Scan(&saved)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return fmt.Errorf("Select from Order failed: %w", err)
+	case bytes.Equal(saved, hash):
+		return ErrOrderDuplicate
+	default:
+		return ErrOrderExists
+	}
+
+	if err = insertOrder(ctx, tx, o, hash); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Commit failed: %w", err)
+	}
+	return nil
+}
+
+const (
+	insertDeliveryQuery = "INSERT INTO delivery (del_id, del_name, Phone, Zip, City, Address, Region, Email)	Values ($1, $2, $3, $4, $5, $6, $7, $8)"
+	insertPaymentQuery  = "INSERT INTO payment (pay_id, Transaction, RequestID, Currency, Provider, Amount, PaymentDt, Bank, DeliveryCost, GoodsTotal, CustomFee)	Values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"
+	insertOrderQuery    = "INSERT INTO orders (OrderUID, TrackNumber, Entry, Deliveries, Pays, Locale, InternalSignature, CustomerID, DeliveryService, Shardkey, SmID, DateCreated, OofShard, PayloadHash)	Values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)"
+	// Товар принадлежит заказу и хранит свою позицию в нем, поэтому одинаковые ChrtID в разных заказах не конфликтуют
+	insertItemQuery = "INSERT INTO item (orderid, Position, ChrtID, TrackNumber, Price, Rid, Item_name, Sale, Size, TotalPrice, NmID, Brand, Status)	Values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)"
+)
+
+// insertOrder - вставка заказа, его доставки, оплаты и товаров в транзакции tx за одно обращение к БД:
+// ключи доставки и оплаты создаются на стороне клиента, поэтому запросы не ждут друг друга
+func insertOrder(ctx context.Context, tx pgx.Tx, o Order, hash []byte) error {
+	DelId, PayId := newUUID(), newUUID()
+	b := &pgx.Batch{}
+	b.Queue(insertDeliveryQuery, DelId, o.Deliveries.Name, o.Deliveries.Phone, o.Deliveries.Zip, o.Deliveries.City, o.Deliveries.Address, o.Deliveries.Region, o.Deliveries.Email)
+	b.Queue(insertPaymentQuery, PayId, o.Pays.Transaction, o.Pays.RequestID, o.Pays.Currency, o.Pays.Provider, o.Pays.Amount, o.Pays.PaymentDt, o.Pays.Bank, o.Pays.DeliveryCost, o.Pays.GoodsTotal, o.Pays.CustomFee)
+	b.Queue(insertOrderQuery, o.OrderUID, o.TrackNumber, o.Entry, DelId, PayId, o.Locale, o.InternalSignature, o.CustomerID, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard, hash)
+	for pos, i := range o.Items {
+		b.Queue(insertItemQuery, o.OrderUID, pos, i.ChrtID, i.TrackNumber, i.Price, i.Rid, i.Name, i.Sale, i.Size, i.TotalPrice, i.NmID, i.Brand, i.Status)
+	}
+
+	results := tx.SendBatch(ctx, b)
+	steps := []string{"Delivery", "Payment", "Order"}
+	for n := 0; n < b.Len(); n++ {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			step := "Items"
+			if n < len(steps) {
+				step = steps[n]
+			}
+			return fmt.Errorf("Insert to %s failed: %w", step, err)
+		}
+	}
+	return results.Close()
+}
+
+// newUUID - случайный UUID версии 4 для ключей доставки и оплаты
+func newUUID() [16]byte {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+// orderAggregateQuery - заказ вместе с доставкой, оплатой и товарами одной строкой JSON в формате Order.
+// Товары заказа идут в порядке их позиций, дата создания без часового пояса считается UTC
+const orderAggregateQuery = `select o.OrderUID, json_build_object(
+	'order_uid', o.OrderUID, 'track_number', o.TrackNumber, 'entry', o.Entry,
+	'delivery', json_build_object('name', d.del_name, 'phone', d.Phone, 'zip', d.Zip, 'city', d.City,
+		'address', d.Address, 'region', d.Region, 'email', d.Email),
+	'payment', json_build_object('transaction', p.Transaction, 'request_id', p.RequestID, 'currency', p.Currency,
+		'provider', p.Provider, 'amount', p.Amount, 'payment_dt', p.PaymentDt, 'bank', p.Bank,
+		'delivery_cost', p.DeliveryCost, 'goods_total', p.GoodsTotal, 'custom_fee', p.CustomFee),
+	'items', (select json_agg(json_build_object('chrt_id', i.ChrtID, 'track_number', i.TrackNumber, 'price', i.Price,
+			'rid', i.Rid, 'name', i.Item_name, 'sale', i.Sale, 'size', i.Size, 'total_price', i.TotalPrice,
+			'nm_id', i.NmID, 'brand', i.Brand, 'status', i.Status) order by i.Position)
+		from item i where i.orderid = o.OrderUID),
+	'locale', o.Locale, 'internal_signature', o.InternalSignature, 'customer_id', o.CustomerID,
+	'delivery_service', o.DeliveryService, 'shardkey', o.Shardkey, 'sm_id', o.SmID,
+	'date_created', o.DateCreated at time zone 'UTC', 'oof_shard', o.OofShard)
+from orders o
+	left join delivery d on d.del_id = o.Deliveries
+	left join payment p on p.pay_id = o.Pays
+where o.OrderUID = any($1)`
+
+// Get - чтение заказа со всеми связанными записями одним запросом, если его нет - ErrOrderNotFound
+func (s *PgOrderStore) Get(ctx context.Context, uid string) (Order, error) {
+	orders, err := s.GetMany(ctx, []string{uid})
+	if o, ok := orders[uid]; ok {
+		return o, nil
+	}
+	if err != nil {
+		return Order{OrderUID: uid}, err
+	}
+	return Order{OrderUID: uid}, ErrOrderNotFound
+}
+
+// GetMany - чтение заказов uids одним запросом, отсутствующих заказов в результате нет.
+// Заказ, который не разбирается, не мешает читать остальные: ошибки таких заказов
+// оборачивают ErrOrderCorrupt и возвращаются вместе с остальными заказами
+func (s *PgOrderStore) GetMany(ctx context.Context, uids []string) (map[string]Order, error) {
+	orders := make(map[string]Order, len(uids))
+	if len(uids) == 0 {
+		return orders, nil
+	}
+
+	rows, err := s.pool.Query(ctx, orderAggregateQuery, uids)
+	if err != nil {
+		return nil, fmt.Errorf("Select from Order failed: %w", err)
+	}
+	defer rows.Close()
+
+	var corrupt []error
+	for rows.Next() {
+		var uid string
+		var data []byte
+		if err = rows.Scan(&uid, &data); err != nil {
+			return nil, fmt.Errorf("Scanning rows from selected orders failed: %w", err)
+		}
+		var o Order
+		if err = json.Unmarshal(data, &o); err != nil {
+			corrupt = append(corrupt, fmt.Errorf("%w: decoding order %s failed: %v", ErrOrderCorrupt, uid, err))
+			continue
+		}
+		orders[uid] = o
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Select from Order failed: %w", err)
+	}
+	return orders, errors.Join(corrupt...)
+}
+
+// List - страница OrderUID от новых заказов к старым. Курсор - дата создания последнего заказа страницы
+// в текстовом виде Postgres и его OrderUID через '|'. Результат читается целиком, чтобы не держать запрос открытым
+func (s *PgOrderStore) List(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	query := `select orderuid, coalesce(DateCreated, '-infinity')::text from orders`
+	args := []interface{}{limit}
+	if cursor != "" {
+		created, uid, ok := strings.Cut(cursor, "|")
+		if !ok {
+			return nil, "", fmt.Errorf("Bad orders cursor %q", cursor)
+		}
+		query += ` where (coalesce(DateCreated, '-infinity'), orderuid) < ($2::timestamp, $3)`
+		args = append(args, created, uid)
+	}
+	query += ` order by coalesce(DateCreated, '-infinity') desc, orderuid desc limit $1`
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("Select page of orders failed: %w", err)
+	}
+	defer rows.Close()
+
+	uids := make([]string, 0, limit)
+	var created string
+	for rows.Next() {
+		var uid string
+		if err = rows.Scan(&uid, &created); err != nil {
+			return nil, "", fmt.Errorf("Scanning page of orders failed: %w", err)
+		}
+		uids = append(uids, uid)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("Select page of orders failed: %w", err)
+	}
+	if len(uids) < limit {
+		return uids, "", nil
+	}
+	return uids, created + "|" + uids[len(uids)-1], nil
+}
+
+// Delete - удаление заказа вместе с доставкой и оплатой в одной транзакции, товары удаляются каскадно
+func (s *PgOrderStore) Delete(ctx context.Context, uid string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Begin transaction failed: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var DelId, PayId sql.NullString
+	query := `delete from orders where orderuid = $1 returning Deliveries, Pays`
+	err = tx.QueryRow(ctx, query, uid).Scan(&DelId, &PayId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("Delete from Order failed: %w", err)
+	}
+	if _, err = tx.Exec(ctx, `delete from delivery where del_id = $1`, DelId); err != nil {
+		return fmt.Errorf("Delete from Delivery failed: %w", err)
+	}
+	if _, err = tx.Exec(ctx, `delete from payment where pay_id = $1`, PayId); err != nil {
+		return fmt.Errorf("Delete from Payment failed: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("Commit failed: %w", err)
+	}
+	return nil
+}
+
+// Exists - есть ли заказ в таблице orders
+func (s *PgOrderStore) Exists(ctx context.Context, uid string) (bool, error) {
+	var exists bool
+	err := s.pool.QueryRow(ctx, `select exists(select 1 from orders where orderuid = $1)`, uid).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("Select from Order failed: %w", err)
+	}
+	return exists, nil
+}
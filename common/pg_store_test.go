@@ -0,0 +1,200 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"os"
+	"testing"
+)
+
+// testPgStore - хранилище в пустой базе Postgres из переменной окружения L0_TEST_DSN.
+// Все заказы базы удаляются, поэтому нужна отдельная тестовая база. Без переменной тест пропускается
+func testPgStore(tb testing.TB) (*PgOrderStore, *pgxpool.Pool) {
+	tb.Helper()
+	dsn := os.Getenv("L0_TEST_DSN")
+	if dsn == "" {
+		tb.Skip("L0_TEST_DSN is not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(pool.Close)
+	if _, err = Migrate(ctx, pool); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err = pool.Exec(ctx, `truncate orders, item, delivery, payment cascade`); err != nil {
+		tb.Fatal(err)
+	}
+	return NewPgOrderStore(pool), pool
+}
+
+// saveTestOrders - сохранение n сгенерированных заказов, возвращает их OrderUID
+func saveTestOrders(tb testing.TB, s *PgOrderStore, n int) []string {
+	tb.Helper()
+	orders := make([]Order, n)
+	uids := make([]string, n)
+	for i := range orders {
+		orders[i] = *NewOrderGen()
+		uids[i] = orders[i].OrderUID
+	}
+	for i, err := range s.SaveMany(context.Background(), orders) {
+		if err != nil {
+			tb.Fatalf("saving order %s: %v", uids[i], err)
+		}
+	}
+	return uids
+}
+
+func TestPgOrderStoreGetManySkipsCorrupt(t *testing.T) {
+	s, pool := testPgStore(t)
+	ctx := context.Background()
+	uids := saveTestOrders(t, s, 3)
+	// Дата infinity допустима в Postgres, но не разбирается как time.Time
+	if _, err := pool.Exec(ctx, `update orders set DateCreated = 'infinity' where orderuid = $1`, uids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := s.GetMany(ctx, append(uids, "missing"))
+	if !errors.Is(err, ErrOrderCorrupt) {
+		t.Fatalf("GetMany error = %v, want ErrOrderCorrupt", err)
+	}
+	if len(orders) != 2 {
+		t.Fatalf("GetMany returned %d orders, want 2", len(orders))
+	}
+	if _, ok := orders[uids[0]]; ok {
+		t.Fatal("corrupt order returned")
+	}
+	for _, uid := range uids[1:] {
+		if orders[uid].OrderUID != uid {
+			t.Fatalf("order %s not returned", uid)
+		}
+	}
+
+	if _, err = s.Get(ctx, uids[0]); !errors.Is(err, ErrOrderCorrupt) {
+		t.Fatalf("Get corrupt order error = %v, want ErrOrderCorrupt", err)
+	}
+	if _, err = s.Get(ctx, "missing"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("Get missing order error = %v, want ErrOrderNotFound", err)
+	}
+}
+
+func TestPgOrderStoreCanceledContext(t *testing.T) {
+	s, _ := testPgStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Ошибки оборачивают причину, поэтому отмену и истечение времени можно отличить от других ошибок
+	if _, err := s.GetMany(ctx, []string{"a"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetMany error = %v, want context.Canceled", err)
+	}
+	if _, _, err := s.List(ctx, "", 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("List error = %v, want context.Canceled", err)
+	}
+	if _, err := s.Exists(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Exists error = %v, want context.Canceled", err)
+	}
+}
+
+// Пачка сохраняется с теми же результатами, что и последовательные Save, даже если в ней есть
+// отвергнутый заказ и другой заказ с тем же OrderUID
+func TestPgOrderStoreSaveManyRejectedDuplicateUID(t *testing.T) {
+	s, _ := testPgStore(t)
+	ctx := context.Background()
+	valid := *NewOrderGen()
+	// Нулевой байт Postgres в тексте не принимает
+	rejected := valid
+	rejected.TrackNumber = "bad\x00track"
+	other := *NewOrderGen()
+
+	orders := []Order{rejected, valid, other, rejected, valid}
+	want := []error{ErrOrderRejected, nil, nil, ErrOrderExists, ErrOrderDuplicate}
+	errs := s.SaveMany(ctx, orders)
+	for i, err := range errs {
+		if !errors.Is(err, want[i]) {
+			t.Fatalf("SaveMany[%d] = %v, want %v", i, err, want[i])
+		}
+	}
+
+	for _, o := range []Order{valid, other} {
+		saved, err := s.Get(ctx, o.OrderUID)
+		if err != nil {
+			t.Fatalf("Get %s: %v", o.OrderUID, err)
+		}
+		if saved.TrackNumber != o.TrackNumber {
+			t.Fatalf("stored TrackNumber = %q, want %q", saved.TrackNumber, o.TrackNumber)
+		}
+	}
+}
+
+// getOrderPerTable - чтение заказа прежним способом: отдельными запросами к orders, delivery, payment и item.
+// Нужно только для сравнения с orderAggregateQuery в BenchmarkPgOrderStoreGetMany
+func getOrderPerTable(ctx context.Context, pool *pgxpool.Pool, uid string) (Order, error) {
+	o := Order{OrderUID: uid}
+	var DelId, PayId sql.NullString
+	query := `select TrackNumber, Entry, Deliveries, Pays, Locale, InternalSignature, CustomerID, DeliveryService, Shardkey, SmID, DateCreated, OofShard from orders where orderuid = $1`
+	err := pool.QueryRow(ctx, query, uid).Scan(&o.TrackNumber, &o.Entry, &DelId, &PayId, &o.Locale, &o.InternalSignature, &o.CustomerID, &o.DeliveryService, &o.Shardkey, &o.SmID, &o.DateCreated, &o.OofShard)
+	if err != nil {
+		return o, fmt.Errorf("Select from Order failed: %w", err)
+	}
+
+	query = `select del_name, Phone, Zip, City, Address, Region, Email from delivery where del_id = $1`
+	err = pool.QueryRow(ctx, query, DelId).Scan(&o.Deliveries.Name, &o.Deliveries.Phone, &o.Deliveries.Zip, &o.Deliveries.City, &o.Deliveries.Address, &o.Deliveries.Region, &o.Deliveries.Email)
+	if err != nil {
+		return o, fmt.Errorf("Select from Delivery failed: %w", err)
+	}
+
+	query = `select Transaction, RequestID, Currency, Provider, Amount, PaymentDt, Bank, DeliveryCost, GoodsTotal, CustomFee from payment where pay_id = $1`
+	err = pool.QueryRow(ctx, query, PayId).Scan(&o.Pays.Transaction, &o.Pays.RequestID, &o.Pays.Currency, &o.Pays.Provider, &o.Pays.Amount, &o.Pays.PaymentDt, &o.Pays.Bank, &o.Pays.DeliveryCost, &o.Pays.GoodsTotal, &o.Pays.CustomFee)
+	if err != nil {
+		return o, fmt.Errorf("Select from Payment failed: %w", err)
+	}
+
+	query = `select ChrtID, TrackNumber, Price, Rid, Item_name, Sale, Size, TotalPrice, NmID, Brand, Status from item where orderid = $1 order by Position`
+	rows, err := pool.Query(ctx, query, uid)
+	if err != nil {
+		return o, fmt.Errorf("Select from Items failed: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i Item
+		if err = rows.Scan(&i.ChrtID, &i.TrackNumber, &i.Price, &i.Rid, &i.Name, &i.Sale, &i.Size, &i.TotalPrice, &i.NmID, &i.Brand, &i.Status); err != nil {
+			return o, fmt.Errorf("Scanning rows from selected items failed: %w", err)
+		}
+		o.Items = append(o.Items, i)
+	}
+	return o, rows.Err()
+}
+
+// BenchmarkPgOrderStoreGetMany - чтение пачки заказов одним запросом (GetMany)
+// и прежним способом, четырьмя запросами на каждый заказ
+func BenchmarkPgOrderStoreGetMany(b *testing.B) {
+	s, pool := testPgStore(b)
+	ctx := context.Background()
+	uids := saveTestOrders(b, s, 100)
+
+	for _, size := range []int{1, 10, 100} {
+		batch := uids[:size]
+		b.Run(fmt.Sprintf("aggregate/batch=%d", size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				orders, err := s.GetMany(ctx, batch)
+				if err != nil || len(orders) != size {
+					b.Fatalf("GetMany = %d orders, %v", len(orders), err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("per-table/batch=%d", size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for _, uid := range batch {
+					if _, err := getOrderPerTable(ctx, pool, uid); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
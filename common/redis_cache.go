@@ -0,0 +1,294 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RedisConfig - параметры подключения к серверу Redis
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+	// Максимальное количество простаивающих соединений в пуле
+	PoolSize int
+	// Таймаут подключения и выполнения одной команды
+	Timeout time.Duration
+	// Префикс ключей заказов, позволяет делить один сервер между окружениями
+	Prefix string
+	// Время жизни заказа по умолчанию, 0 - бессрочно
+	DefaultExpiration time.Duration
+	// Способ отсчета времени жизни и опережающая перезагрузка для записей без собственного правила
+	ExpirationMode ExpirationMode
+	RefreshAhead   time.Duration
+}
+
+// redisEntry - значение ключа в Redis: заказ вместе с правилом истечения,
+// которое нужно знать при чтении для продления и опережающей перезагрузки
+type redisEntry struct {
+	Policy ExpirationPolicy `json:"policy"`
+	Order  Order            `json:"order"`
+}
+
+// RedisOrderCache - кэш заказов на сервере Redis, общий для всех реплик клиента.
+// Заказы хранятся в виде JSON под ключами Prefix+OrderUID
+type RedisOrderCache struct {
+	pool              *respPool
+	prefix            string
+	defaultExpiration time.Duration
+	defaultPolicy     ExpirationPolicy
+	loads             loadGroup[string, Order]
+	refresher         func(string) (Order, error)
+	refreshing        sync.Map
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewRedisOrderCache - подключение к серверу Redis, проверяется командой PING
+func NewRedisOrderCache(cfg RedisConfig) (*RedisOrderCache, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = 8
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "order:"
+	}
+	r := &RedisOrderCache{
+		pool:              newRespPool(cfg.Addr, cfg.Password, cfg.DB, cfg.PoolSize, cfg.Timeout),
+		prefix:            cfg.Prefix,
+		defaultExpiration: cfg.DefaultExpiration,
+		defaultPolicy:     ExpirationPolicy{Mode: cfg.ExpirationMode, RefreshAhead: cfg.RefreshAhead},
+	}
+	if _, err := r.pool.do("PING"); err != nil {
+		r.pool.close()
+		return nil, fmt.Errorf("Redis ping failed: %v", err)
+	}
+	return r, nil
+}
+
+// Get - получение заказа. Ошибки связи с сервером считаются промахом
+func (r *RedisOrderCache) Get(uid string) (Order, bool) {
+	o, found, err := r.get(uid)
+	if err != nil {
+		fmt.Println(time.Now(), "Redis GET failed:", err)
+	}
+	if found {
+		r.hits.Add(1)
+	} else {
+		r.misses.Add(1)
+	}
+	return o, found
+}
+
+// get - чтение и разбор заказа с продлением скользящего времени жизни
+// и запуском опережающей перезагрузки
+func (r *RedisOrderCache) get(uid string) (o Order, found bool, err error) {
+	key := r.prefix + uid
+	e, found, err := r.fetch(key)
+	if !found {
+		return o, false, err
+	}
+
+	p := e.Policy
+	if p.Mode == ExpireSliding && p.TTL > 0 {
+		if _, err = r.pool.do("PEXPIRE", key, strconv.FormatInt(p.TTL.Milliseconds(), 10)); err != nil {
+			return e.Order, true, err
+		}
+	} else if p.RefreshAhead > 0 && r.refresher != nil {
+		reply, err := r.pool.do("PTTL", key)
+		if err != nil {
+			return e.Order, true, err
+		}
+		if ttl, _ := reply.(int64); ttl >= 0 && time.Duration(ttl)*time.Millisecond <= p.RefreshAhead {
+			r.scheduleRefresh(uid, p)
+		}
+	}
+	return e.Order, true, nil
+}
+
+// fetch - чтение и разбор значения ключа
+func (r *RedisOrderCache) fetch(key string) (e redisEntry, found bool, err error) {
+	reply, err := r.pool.do("GET", key)
+	if err != nil || reply == nil {
+		return e, false, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return e, false, errRespProtocol
+	}
+	if err = json.Unmarshal(data, &e); err != nil {
+		return e, false, err
+	}
+	return e, true, nil
+}
+
+// Peek - получение заказа без учета в статистике, продления скользящего времени жизни
+// и опережающей перезагрузки. Ошибки связи с сервером считаются промахом
+func (r *RedisOrderCache) Peek(uid string) (Order, bool) {
+	e, found, err := r.fetch(r.prefix + uid)
+	if err != nil {
+		fmt.Println(time.Now(), "Redis GET failed:", err)
+	}
+	return e.Order, found
+}
+
+// Set - запись заказа с перезаписью, duration 0 - время жизни по умолчанию
+func (r *RedisOrderCache) Set(uid string, o Order, duration time.Duration) error {
+	p := r.defaultPolicy
+	p.TTL = duration
+	return r.SetWithPolicy(uid, o, p)
+}
+
+// SetWithPolicy - запись заказа с перезаписью и собственным правилом истечения
+func (r *RedisOrderCache) SetWithPolicy(uid string, o Order, p ExpirationPolicy) error {
+	return r.set(uid, o, p, false)
+}
+
+// set - запись заказа, xx - только если ключ уже существует
+func (r *RedisOrderCache) set(uid string, o Order, p ExpirationPolicy, xx bool) error {
+	if p.TTL == 0 {
+		p.TTL = r.defaultExpiration
+	}
+	data, err := json.Marshal(redisEntry{Policy: p, Order: o})
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", r.prefix + uid, string(data)}
+	if p.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(p.TTL.Milliseconds(), 10))
+	}
+	if xx {
+		args = append(args, "XX")
+	}
+	_, err = r.pool.do(args...)
+	return err
+}
+
+// SetRefresher - функция перезагрузки заказов с опережающим обновлением.
+// Должна вызываться до начала работы с кэшем
+func (r *RedisOrderCache) SetRefresher(refresher func(string) (Order, error)) {
+	r.refresher = refresher
+}
+
+// scheduleRefresh - запуск фоновой перезагрузки заказа, если эта реплика еще не перезагружает его
+func (r *RedisOrderCache) scheduleRefresh(uid string, p ExpirationPolicy) {
+	if _, running := r.refreshing.LoadOrStore(uid, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer r.refreshing.Delete(uid)
+
+		o, err := r.refresher(uid)
+		if err != nil {
+			fmt.Println(time.Now(), "cache refresh failed:", err)
+			return
+		}
+		// XX - не возвращаем заказ, удаленный, пока шла перезагрузка
+		if err = r.set(uid, o, p, true); err != nil {
+			fmt.Println(time.Now(), "Redis SET failed:", err)
+		}
+	}()
+}
+
+// Delete - удаление заказа, если его нет - ErrKeyNotFound
+func (r *RedisOrderCache) Delete(uid string) error {
+	reply, err := r.pool.do("DEL", r.prefix+uid)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// GetOrLoad - получение заказа, а при промахе - загрузка через loader и запись в Redis.
+// Одновременные промахи внутри этой реплики вызывают loader один раз
+func (r *RedisOrderCache) GetOrLoad(uid string, loader func(string) (Order, error)) (Order, error) {
+	if o, found := r.Get(uid); found {
+		return o, nil
+	}
+
+	return r.loads.do(uid, func() (Order, error) {
+		o, err := loader(uid)
+		if err != nil {
+			return o, err
+		}
+		if err = r.Set(uid, o, 0); err != nil {
+			fmt.Println(time.Now(), "Redis SET failed:", err)
+		}
+		return o, nil
+	})
+}
+
+// GetOrLoadEncoded - то же, что GetOrLoad. Заказы хранятся в Redis в виде, отличном от ответа,
+// поэтому сериализованного заказа нет (nil)
+func (r *RedisOrderCache) GetOrLoadEncoded(uid string, loader func(string) (Order, error)) (Order, *Encoded, error) {
+	o, err := r.GetOrLoad(uid, loader)
+	return o, nil, err
+}
+
+// Len - количество заказов этого кэша, то есть ключей с префиксом Prefix. Ключи перебираются
+// командой SCAN, поэтому сервер не блокируется, а результат приблизителен, если ключи меняются во время подсчета
+func (r *RedisOrderCache) Len() int {
+	match := redisGlobEscape(r.prefix) + "*"
+	cursor := "0"
+	n := 0
+	for {
+		reply, err := r.pool.do("SCAN", cursor, "MATCH", match, "COUNT", "1000")
+		if err != nil {
+			fmt.Println(time.Now(), "Redis SCAN failed:", err)
+			return n
+		}
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 2 {
+			return n
+		}
+		next, _ := values[0].([]byte)
+		keys, _ := values[1].([]interface{})
+		n += len(keys)
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return n
+		}
+	}
+}
+
+// redisGlobEscape - экранирование спецсимволов шаблона Redis, чтобы префикс совпадал буквально
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// MaxEntries - лимит количества заказов. Вытеснением в Redis управляет сам сервер (maxmemory-policy)
+func (r *RedisOrderCache) MaxEntries() int {
+	return 0
+}
+
+// Stats - статистика обращений этой реплики к Redis
+func (r *RedisOrderCache) Stats() CacheStats {
+	stats := CacheStats{Hits: r.hits.Load(), Misses: r.misses.Load(), Size: r.Len()}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// Close - закрытие соединений с сервером
+func (r *RedisOrderCache) Close() error {
+	return r.pool.close()
+}
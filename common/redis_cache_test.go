@@ -0,0 +1,172 @@
+package common
+
+import (
+	"GoProjectL0/common/redistest"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRedisCache - кэш заказов на сервере redistest, который останавливается вместе с тестом
+func newTestRedisCache(t *testing.T, cfg RedisConfig) (*RedisOrderCache, *redistest.Server) {
+	t.Helper()
+	srv, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	cfg.Addr = srv.Addr()
+	r, err := NewRedisOrderCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, srv
+}
+
+func TestRedisOrderCacheSetGet(t *testing.T) {
+	r, _ := newTestRedisCache(t, RedisConfig{DefaultExpiration: time.Hour})
+	o := *NewOrderGen()
+
+	if _, found := r.Get(o.OrderUID); found {
+		t.Fatal("order found before Set")
+	}
+	if err := r.Set(o.OrderUID, o, 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, found := r.Get(o.OrderUID)
+	if !found {
+		t.Fatal("order not found after Set")
+	}
+	if got.OrderUID != o.OrderUID || got.TrackNumber != o.TrackNumber || len(got.Items) != len(o.Items) {
+		t.Fatalf("Get = %+v, want %+v", got, o)
+	}
+	if stats := r.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("Stats hits/misses = %d/%d, want 1/1", stats.Hits, stats.Misses)
+	}
+}
+
+func TestRedisOrderCacheTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		duration  time.Duration
+		wantFound bool
+	}{
+		{name: "own ttl expired", duration: 20 * time.Millisecond, wantFound: false},
+		{name: "default ttl", duration: 0, wantFound: true},
+		{name: "no expiration", duration: -1, wantFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestRedisCache(t, RedisConfig{DefaultExpiration: time.Hour})
+			o := *NewOrderGen()
+			if err := r.Set(o.OrderUID, o, tt.duration); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			if _, found := r.Get(o.OrderUID); found != tt.wantFound {
+				t.Fatalf("Get found = %v, want %v", found, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestRedisOrderCacheDelete(t *testing.T) {
+	r, _ := newTestRedisCache(t, RedisConfig{})
+	o := *NewOrderGen()
+	if err := r.Set(o.OrderUID, o, 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if err := r.Delete(o.OrderUID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, found := r.Get(o.OrderUID); found {
+		t.Fatal("order found after Delete")
+	}
+	if err := r.Delete(o.OrderUID); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("second Delete = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestRedisOrderCacheGetOrLoad(t *testing.T) {
+	r, _ := newTestRedisCache(t, RedisConfig{})
+	o := *NewOrderGen()
+	var loads atomic.Int32
+	loader := func(uid string) (Order, error) {
+		loads.Add(1)
+		return o, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := r.GetOrLoad(o.OrderUID, loader)
+		if err != nil {
+			t.Fatalf("GetOrLoad: %v", err)
+		}
+		if got.OrderUID != o.OrderUID {
+			t.Fatalf("GetOrLoad = %q, want %q", got.OrderUID, o.OrderUID)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("loader called %d times, want 1", n)
+	}
+
+	errLoad := errors.New("load failed")
+	if _, err := r.GetOrLoad("missing", func(string) (Order, error) { return Order{}, errLoad }); !errors.Is(err, errLoad) {
+		t.Fatalf("GetOrLoad error = %v, want %v", err, errLoad)
+	}
+	if _, found := r.Get("missing"); found {
+		t.Fatal("failed load was cached")
+	}
+}
+
+func TestRedisOrderCacheLen(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+	}{
+		{name: "default prefix", prefix: ""},
+		{name: "glob characters", prefix: "o*[1]?:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, srv := newTestRedisCache(t, RedisConfig{Prefix: tt.prefix})
+			for i := 0; i < 3; i++ {
+				o := *NewOrderGen()
+				if err := r.Set(o.OrderUID, o, 0); err != nil {
+					t.Fatalf("Set: %v", err)
+				}
+			}
+			// Чужие ключи на том же сервере не считаются
+			for _, key := range []string{"session:1", "oX[1]Y:1", "order"} {
+				if _, err := r.pool.do("SET", key, "x"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if n := r.Len(); n != 3 {
+				t.Fatalf("Len = %d, want 3 (server has %d keys)", n, srv.Len())
+			}
+		})
+	}
+}
+
+func TestRedisOrderCachePeek(t *testing.T) {
+	r, _ := newTestRedisCache(t, RedisConfig{})
+	o := *NewOrderGen()
+	if _, found := r.Peek(o.OrderUID); found {
+		t.Fatal("order found before Set")
+	}
+	if err := r.Set(o.OrderUID, o, 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got, found := r.Peek(o.OrderUID); !found || got.OrderUID != o.OrderUID {
+		t.Fatalf("Peek = %q, %v; want %q, true", got.OrderUID, found, o.OrderUID)
+	}
+	if stats := r.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Stats hits/misses = %d/%d after Peek, want 0/0", stats.Hits, stats.Misses)
+	}
+}
@@ -0,0 +1,389 @@
+// Package redistest - сервер, говорящий по протоколу Redis (RESP) и хранящий данные в памяти процесса.
+// Нужен, чтобы проверять RedisOrderCache локально без настоящего Redis.
+// Поддерживается только подмножество команд, которое использует common
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entry - значение ключа со временем истечения, нулевое время - бессрочно
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// Server - сервер RESP в памяти процесса
+type Server struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	data  map[string]entry
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer - запуск сервера на случайном свободном порту 127.0.0.1
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: l, data: make(map[string]entry), conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr - адрес, на котором слушает сервер
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close - остановка сервера с закрытием всех клиентских соединений
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Len - количество неистекших ключей
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for k := range s.data {
+		if _, ok := s.lookup(k); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// handle - обработка команд одного соединения
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.exec(w, args)
+		if err = w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand - чтение команды в виде массива bulk-строк
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected command prefix %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("unexpected argument prefix %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// lookup - значение ключа с удалением истекшего, вызывается под s.mu
+func (s *Server) lookup(key string) (entry, bool) {
+	e, ok := s.data[key]
+	if !ok {
+		return e, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(s.data, key)
+		return e, false
+	}
+	return e, true
+}
+
+// exec - выполнение команды и запись ответа
+func (s *Server) exec(w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		writeError(w, "ERR empty command")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		writeSimple(w, "PONG")
+	case "AUTH", "SELECT":
+		writeSimple(w, "OK")
+	case "GET":
+		if len(args) != 2 {
+			writeError(w, "ERR wrong number of arguments for 'get' command")
+			return
+		}
+		if e, ok := s.lookup(args[1]); ok {
+			writeBulk(w, e.value)
+		} else {
+			writeNil(w)
+		}
+	case "SET":
+		s.set(w, args)
+	case "DEL":
+		var n int64
+		for _, k := range args[1:] {
+			if _, ok := s.lookup(k); ok {
+				delete(s.data, k)
+				n++
+			}
+		}
+		writeInt(w, n)
+	case "EXISTS":
+		var n int64
+		for _, k := range args[1:] {
+			if _, ok := s.lookup(k); ok {
+				n++
+			}
+		}
+		writeInt(w, n)
+	case "PEXPIRE":
+		if len(args) != 3 {
+			writeError(w, "ERR wrong number of arguments for 'pexpire' command")
+			return
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return
+		}
+		e, ok := s.lookup(args[1])
+		if !ok {
+			writeInt(w, 0)
+			return
+		}
+		e.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		s.data[args[1]] = e
+		writeInt(w, 1)
+	case "PTTL":
+		if len(args) != 2 {
+			writeError(w, "ERR wrong number of arguments for 'pttl' command")
+			return
+		}
+		e, ok := s.lookup(args[1])
+		switch {
+		case !ok:
+			writeInt(w, -2)
+		case e.expires.IsZero():
+			writeInt(w, -1)
+		default:
+			writeInt(w, time.Until(e.expires).Milliseconds())
+		}
+	case "DBSIZE":
+		n := 0
+		for k := range s.data {
+			if _, ok := s.lookup(k); ok {
+				n++
+			}
+		}
+		writeInt(w, int64(n))
+	case "SCAN":
+		s.scan(w, args)
+	case "FLUSHDB", "FLUSHALL":
+		s.data = make(map[string]entry)
+		writeSimple(w, "OK")
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+// set - команда SET key value [PX ms | EX s] [NX | XX]
+func (s *Server) set(w *bufio.Writer, args []string) {
+	if len(args) < 3 {
+		writeError(w, "ERR wrong number of arguments for 'set' command")
+		return
+	}
+	e := entry{value: args[2]}
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "PX", "EX":
+			if i+1 >= len(args) {
+				writeError(w, "ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Millisecond
+			if strings.ToUpper(args[i]) == "EX" {
+				unit = time.Second
+			}
+			e.expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+	_, exists := s.lookup(args[1])
+	if (nx && exists) || (xx && !exists) {
+		writeNil(w)
+		return
+	}
+	s.data[args[1]] = e
+	writeSimple(w, "OK")
+}
+
+// scan - команда SCAN cursor [MATCH pattern] [COUNT n]. Все подходящие ключи возвращаются
+// за один вызов с курсором 0, COUNT игнорируется
+func (s *Server) scan(w *bufio.Writer, args []string) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		writeError(w, "ERR syntax error")
+		return
+	}
+	pattern := "*"
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+	var keys []string
+	for k := range s.data {
+		if _, ok := s.lookup(k); ok && match(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	fmt.Fprintf(w, "*2\r\n")
+	writeBulk(w, "0")
+	fmt.Fprintf(w, "*%d\r\n", len(keys))
+	for _, k := range keys {
+		writeBulk(w, k)
+	}
+}
+
+// match - сопоставление ключа с шаблоном Redis: * - любая строка, ? - любой символ,
+// \ - экранирование следующего символа. Классы символов [...] не поддерживаются
+func match(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(key); i >= 0; i-- {
+				if match(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func writeError(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "-%s\r\n", s)
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func writeNil(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
@@ -0,0 +1,225 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RespError - ошибка, которую вернул сервер Redis
+type RespError string
+
+func (e RespError) Error() string {
+	return string(e)
+}
+
+// errRespProtocol - сервер прислал ответ, не соответствующий протоколу RESP
+var errRespProtocol = errors.New("RESP protocol error")
+
+// respConn - соединение с сервером по протоколу RESP
+type respConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+	broken  bool
+}
+
+// dialResp - установка соединения с сервером addr
+func dialResp(addr string, timeout time.Duration) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), timeout: timeout}, nil
+}
+
+// do - отправка команды и чтение ответа. Ответ - string для простых строк, int64 для целых чисел,
+// []byte или nil для bulk-строк, []interface{} для массивов. Ошибка сервера возвращается как RespError,
+// после сетевой ошибки или ошибки протокола соединение помечается сломанным
+func (c *respConn) do(args ...string) (interface{}, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if err := writeRespCommand(c.w, args); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	reply, err := readRespValue(c.r)
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if e, ok := reply.(RespError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// close - закрытие соединения
+func (c *respConn) close() error {
+	return c.conn.Close()
+}
+
+// writeRespCommand - запись команды в виде массива bulk-строк
+func writeRespCommand(w *bufio.Writer, args []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, a := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRespValue - чтение одного значения RESP
+func readRespValue(r *bufio.Reader) (interface{}, error) {
+	line, err := readRespLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errRespProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return RespError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, errRespProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errRespProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errRespProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readRespValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, errRespProtocol
+}
+
+// readRespLine - чтение строки без завершающего \r\n
+func readRespLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errRespProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// respPool - пул соединений с сервером RESP
+type respPool struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	conns    chan *respConn
+}
+
+func newRespPool(addr, password string, db, size int, timeout time.Duration) *respPool {
+	if size < 1 {
+		size = 1
+	}
+	return &respPool{addr: addr, password: password, db: db, timeout: timeout, conns: make(chan *respConn, size)}
+}
+
+// get - свободное соединение из пула или новое
+func (p *respPool) get() (*respConn, error) {
+	select {
+	case c := <-p.conns:
+		return c, nil
+	default:
+	}
+	c, err := dialResp(p.addr, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if p.password != "" {
+		if _, err = c.do("AUTH", p.password); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	if p.db != 0 {
+		if _, err = c.do("SELECT", strconv.Itoa(p.db)); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put - возврат соединения в пул, сломанные и лишние соединения закрываются
+func (p *respPool) put(c *respConn) {
+	if c.broken {
+		c.close()
+		return
+	}
+	select {
+	case p.conns <- c:
+	default:
+		c.close()
+	}
+}
+
+// do - выполнение команды на соединении из пула
+func (p *respPool) do(args ...string) (interface{}, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.put(c)
+
+	return c.do(args...)
+}
+
+// close - закрытие всех свободных соединений пула
+func (p *respPool) close() error {
+	for {
+		select {
+		case c := <-p.conns:
+			c.close()
+		default:
+			return nil
+		}
+	}
+}
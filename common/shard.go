@@ -0,0 +1,130 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// cacheShard - сегмент кэша со своей блокировкой, своими лимитами и своим учетом вытеснения
+type cacheShard[K comparable, V any] struct {
+	sync.RWMutex
+	items map[K]CacheItem[V]
+	// Индекс истечения: куча ключей по времени истечения, бессрочные элементы в конце
+	expirations *keyHeap[K]
+	evictor     evictor[K]
+	bytes       int64
+	maxEntries  int
+	maxBytes    int64
+
+	// Счетчики обращений, изменяются и под блокировкой на чтение, поэтому атомарные
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	expiredOnRead atomic.Uint64
+}
+
+func newCacheShard[K comparable, V any](maxEntries int, maxBytes int64, policy EvictionPolicy) *cacheShard[K, V] {
+	expirations := newKeyHeap[K]()
+	return &cacheShard[K, V]{
+		items:       make(map[K]CacheItem[V]),
+		expirations: expirations,
+		evictor:     newEvictor[K](policy, expirations),
+		maxEntries:  maxEntries,
+		maxBytes:    maxBytes,
+	}
+}
+
+// insert - добавление элемента вместе с его учетом, вызывается под блокировкой на запись
+func (s *cacheShard[K, V]) insert(key K, item CacheItem[V]) {
+	s.items[key] = item
+	s.bytes += item.size
+	s.evictor.add(key, item.Expiration)
+	if item.Expiration > 0 {
+		s.expirations.set(key, item.Expiration)
+	} else {
+		s.expirations.set(key, math.MaxInt64)
+	}
+}
+
+// overflows - превысит ли сегмент лимиты после добавления элемента размером size
+func (s *cacheShard[K, V]) overflows(size int64) bool {
+	if s.maxEntries > 0 && len(s.items)+1 > s.maxEntries {
+		return true
+	}
+	return s.maxBytes > 0 && s.bytes+size > s.maxBytes
+}
+
+// remove - удаление элемента вместе с его учетом, вызывается под блокировкой на запись
+func (s *cacheShard[K, V]) remove(key K) (CacheItem[V], bool) {
+	item, found := s.items[key]
+	if !found {
+		return item, false
+	}
+	s.bytes -= item.size
+	s.evictor.remove(key)
+	s.expirations.delete(key)
+	delete(s.items, key)
+	return item, true
+}
+
+// deleteExpired - удаление истекших к моменту now элементов. Просматриваются только
+// истекшие элементы из начала индекса, поэтому стоимость пропорциональна их количеству
+func (s *cacheShard[K, V]) deleteExpired(now int64) (evicted []evictedItem[K, V]) {
+	s.Lock()
+	defer s.Unlock()
+
+	for {
+		key, expiration, ok := s.expirations.min()
+		if !ok || expiration >= now {
+			return
+		}
+		item, _ := s.remove(key)
+		evicted = append(evicted, evictedItem[K, V]{key, item.Value, ReasonExpired})
+	}
+}
+
+// shardLimit - доля лимита limit, приходящаяся на один из n сегментов, но не меньше 1
+func shardLimit[T int | int64](limit T, n int) T {
+	if limit <= 0 {
+		return 0
+	}
+	per := (limit + T(n) - 1) / T(n)
+	if per < 1 {
+		per = 1
+	}
+	return per
+}
+
+// keyHasher - вычисление хэша ключа для выбора сегмента
+type keyHasher[K comparable] struct {
+	seed maphash.Seed
+}
+
+func newKeyHasher[K comparable]() keyHasher[K] {
+	return keyHasher[K]{seed: maphash.MakeSeed()}
+}
+
+// hash - хэш ключа. Для строк и целых чисел считается без аллокаций,
+// остальные типы ключей хэшируются по строковому представлению
+func (h keyHasher[K]) hash(key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return maphash.String(h.seed, k)
+	case int:
+		return h.hashUint(uint64(k))
+	case int64:
+		return h.hashUint(uint64(k))
+	case uint64:
+		return h.hashUint(k)
+	}
+	return maphash.String(h.seed, fmt.Sprintf("%#v", key))
+}
+
+func (h keyHasher[K]) hashUint(v uint64) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return maphash.Bytes(h.seed, b[:])
+}
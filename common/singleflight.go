@@ -0,0 +1,44 @@
+package common
+
+import "sync"
+
+// loadCall - загрузка значения, которую ожидают все одновременно промахнувшиеся по ключу
+type loadCall[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+}
+
+// loadGroup - объединение одновременных загрузок одного ключа в одну. Нулевое значение готово к работе
+type loadGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+// do - вызов fn для ключа key. Если загрузка этого ключа уже идет, fn не вызывается,
+// а возвращается результат идущей загрузки
+func (g *loadGroup[K, V]) do(key K, fn func() (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*loadCall[V])
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &loadCall[V]{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	call.value, call.err = fn()
+	return call.value, call.err
+}
@@ -0,0 +1,61 @@
+package common
+
+import "reflect"
+
+// ApproxSize - приблизительный объем памяти в байтах, занимаемый значением v.
+// Учитываются строки, слайсы, массивы, мапы и вложенные структуры; указатели не разыменовываются,
+// так как обычно ссылаются на разделяемые данные (например, *time.Location внутри time.Time)
+func ApproxSize(v any) int64 {
+	if v == nil {
+		return 0
+	}
+	rv := reflect.ValueOf(v)
+	return int64(rv.Type().Size()) + dynamicSize(rv)
+}
+
+// dynamicSize - объем памяти, выделенной под значение v сверх его собственного размера
+func dynamicSize(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return 0
+		}
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += dynamicSize(v.Index(i))
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		for i := 0; i < v.Len(); i++ {
+			size += dynamicSize(v.Index(i))
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += dynamicSize(v.Field(i))
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() {
+			return 0
+		}
+		entry := int64(v.Type().Key().Size() + v.Type().Elem().Size())
+		size := int64(v.Len()) * entry
+		iter := v.MapRange()
+		for iter.Next() {
+			size += dynamicSize(iter.Key()) + dynamicSize(iter.Value())
+		}
+		return size
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		elem := v.Elem()
+		return int64(elem.Type().Size()) + dynamicSize(elem)
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package common
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotMagic - сигнатура файла снимка кэша
+const snapshotMagic = "L0CACHE"
+
+// snapshotVersion - версия формата снимка, увеличивается при несовместимых изменениях
+const snapshotVersion = 1
+
+// ErrSnapshotFormat - файл не является снимком кэша или записан в неподдерживаемой версии формата
+var ErrSnapshotFormat = errors.New("Unsupported cache snapshot format")
+
+// snapshotHeader - заголовок снимка, следующий сразу за сигнатурой и версией
+type snapshotHeader struct {
+	Saved time.Time
+	Count int
+}
+
+// snapshotEntry - элемент кэша в снимке. Время истечения хранится абсолютным,
+// поэтому после загрузки элемент проживет ровно столько, сколько оставалось
+type snapshotEntry[K comparable, V any] struct {
+	Key        K
+	Value      V
+	Created    time.Time
+	Expiration int64
+	Policy     ExpirationPolicy
+}
+
+// Save - запись снимка неустаревших элементов кэша в w.
+// Формат: сигнатура, байт версии и gob-поток из заголовка и элементов
+func (c *Cache[K, V]) Save(w io.Writer) error {
+	var entries []snapshotEntry[K, V]
+	now := c.clock.Now().UnixNano()
+	for _, s := range c.shards {
+		s.RLock()
+		for k, i := range s.items {
+			if !i.expired(now) {
+				entries = append(entries, snapshotEntry[K, V]{k, i.Value, i.Created, i.Expiration, i.policy})
+			}
+		}
+		s.RUnlock()
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(snapshotVersion); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(bw)
+	if err := enc.Encode(snapshotHeader{Saved: c.clock.Now(), Count: len(entries)}); err != nil {
+		return fmt.Errorf("Encoding snapshot header failed: %v", err)
+	}
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("Encoding snapshot entry failed: %v", err)
+		}
+	}
+	return bw.Flush()
+}
+
+// Load - загрузка элементов из снимка, прочитанного из r. Истекшие за время простоя элементы
+// пропускаются, уже имеющиеся в кэше ключи не перезаписываются. Возвращает количество загруженных
+func (c *Cache[K, V]) Load(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return 0, ErrSnapshotFormat
+	}
+	if string(magic[:len(snapshotMagic)]) != snapshotMagic || magic[len(snapshotMagic)] != snapshotVersion {
+		return 0, ErrSnapshotFormat
+	}
+
+	dec := gob.NewDecoder(br)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("Decoding snapshot header failed: %v", err)
+	}
+
+	loaded := 0
+	for i := 0; i < header.Count; i++ {
+		var e snapshotEntry[K, V]
+		if err := dec.Decode(&e); err != nil {
+			return loaded, fmt.Errorf("Decoding snapshot entry failed: %v", err)
+		}
+		if e.Expiration > 0 && c.clock.Now().UnixNano() > e.Expiration {
+			continue
+		}
+		_, err := c.writeAt(e.Key, e.Value, e.Created, e.Expiration, e.Policy, func(item CacheItem[V], found bool) error {
+			if found {
+				return ErrKeyExists
+			}
+			return nil
+		})
+		if err == nil {
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
+// SaveFile - запись снимка в файл path. Снимок пишется во временный файл и переименовывается,
+// поэтому при сбое во время записи предыдущий снимок остается целым
+func (c *Cache[K, V]) SaveFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = c.Save(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile - загрузка снимка из файла path, см. Load
+func (c *Cache[K, V]) LoadFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return c.Load(f)
+}
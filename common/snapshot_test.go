@@ -0,0 +1,86 @@
+package common
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	src, srcClock := newTestCache(0)
+	src.Set("short", 1, 10*time.Minute)
+	src.Set("long", 2, 2*time.Hour)
+	src.Set("forever", 3, -1)
+	src.Set("existing", 4, time.Hour)
+	src.Set("gone", 5, time.Second)
+	// Истекшие к моменту сохранения элементы в снимок не попадают
+	srcClock.Advance(time.Minute)
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	if err := src.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+
+	// Процесс был остановлен полчаса, за это время "short" истек
+	dst, dstClock := newTestCache(time.Minute)
+	dstClock.Set(testStart.Add(30 * time.Minute))
+	dst.Set("existing", 100, -1)
+	loaded, err := dst.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if loaded != 2 {
+		t.Fatalf("LoadFile loaded %d entries, want 2", loaded)
+	}
+
+	checks := []struct {
+		key       string
+		wantValue int
+		wantFound bool
+	}{
+		{key: "short", wantFound: false},
+		{key: "gone", wantFound: false},
+		{key: "long", wantValue: 2, wantFound: true},
+		{key: "forever", wantValue: 3, wantFound: true},
+		// Уже имеющийся ключ не перезаписывается
+		{key: "existing", wantValue: 100, wantFound: true},
+	}
+	for _, c := range checks {
+		if got, found := dst.Peek(c.key); got != c.wantValue || found != c.wantFound {
+			t.Fatalf("Peek(%q) = %d, %v; want %d, %v", c.key, got, found, c.wantValue, c.wantFound)
+		}
+	}
+
+	// Время истечения сохранено абсолютным: элемент живет до того же момента, что и в исходном кэше
+	dstClock.Set(testStart.Add(2 * time.Hour))
+	if _, found := dst.Peek("long"); !found {
+		t.Fatal("long expired before its saved expiration")
+	}
+	dstClock.Advance(time.Nanosecond)
+	if _, found := dst.Peek("long"); found {
+		t.Fatal("long outlived its saved expiration")
+	}
+	if _, found := dst.Peek("forever"); !found {
+		t.Fatal("entry without expiration expired after load")
+	}
+}
+
+func TestCacheSnapshotFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "wrong magic", data: "NOTCACHE\x01"},
+		{name: "wrong version", data: snapshotMagic + "\x02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCache(0)
+			if _, err := c.Load(strings.NewReader(tt.data)); !errors.Is(err, ErrSnapshotFormat) {
+				t.Fatalf("Load = %v, want ErrSnapshotFormat", err)
+			}
+		})
+	}
+}
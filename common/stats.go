@@ -0,0 +1,54 @@
+package common
+
+import "time"
+
+// CacheStats - сводная статистика работы кэша
+type CacheStats struct {
+	// Обращения, нашедшие неустаревший элемент
+	Hits uint64 `json:"hits"`
+	// Обращения, не нашедшие элемент, включая устаревшие
+	Misses uint64 `json:"misses"`
+	// Промахи из-за того, что элемент устарел, но еще не удален сборщиком мусора
+	ExpiredOnRead uint64 `json:"expired_on_read"`
+	// Доля попаданий среди всех обращений
+	HitRatio float64 `json:"hit_ratio"`
+	// Удаленные из кэша элементы
+	Evictions EvictionStats `json:"evictions"`
+	// Количество элементов
+	Size int `json:"size"`
+	// Приблизительный объем элементов в байтах, считается только при заданном MaxBytes
+	Bytes int64 `json:"bytes"`
+	// Возраст самого старого элемента
+	OldestAge time.Duration `json:"oldest_age_ns"`
+}
+
+// Stats - снимок статистики кэша. Для подсчета возраста самого старого элемента
+// сегменты просматриваются целиком, поэтому вызывать метод на каждый запрос не стоит
+func (c *Cache[K, V]) Stats() CacheStats {
+	stats := CacheStats{Evictions: c.EvictionStats()}
+
+	var oldest time.Time
+	for _, s := range c.shards {
+		stats.Hits += s.hits.Load()
+		stats.Misses += s.misses.Load()
+		stats.ExpiredOnRead += s.expiredOnRead.Load()
+
+		s.RLock()
+		stats.Size += len(s.items)
+		stats.Bytes += s.bytes
+		for _, i := range s.items {
+			if oldest.IsZero() || i.Created.Before(oldest) {
+				oldest = i.Created
+			}
+		}
+		s.RUnlock()
+	}
+
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	if !oldest.IsZero() {
+		stats.OldestAge = c.clock.Now().Sub(oldest)
+	}
+	return stats
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+)
+
+// ErrOrderExists - заказ с таким OrderUID уже сохранен, и его содержимое отличается от сохраняемого
+// (или неизвестно, если заказ сохранен до появления хэшей). Сохраненный заказ не меняется
+var ErrOrderExists = errors.New("Order already exists")
+
+// ErrOrderDuplicate - точно такой же заказ уже сохранен, например при повторной доставке сообщения
+var ErrOrderDuplicate = errors.New("Order already saved")
+
+// ErrOrderRejected - хранилище отказалось сохранять заказ из-за его содержимого
+// (например, нарушены ограничения БД), повторная попытка с тем же заказом не поможет
+var ErrOrderRejected = errors.New("Order rejected by store")
+
+// ErrOrderCorrupt - сохраненный заказ не удалось прочитать: его данные не разбираются как Order
+// (например, дата создания infinity). Повторное чтение не поможет
+var ErrOrderCorrupt = errors.New("Order is corrupt")
+
+// OrderStore - постоянное хранилище заказов.
+// Реализации: PgOrderStore (Postgres) и MemoryOrderStore (в памяти процесса)
+type OrderStore interface {
+	// Save - сохранение нового заказа целиком или никак. Уже сохраненный OrderUID проверяется до записи чего-либо:
+	// ErrOrderDuplicate для того же содержимого, ErrOrderExists для другого. Некорректный заказ - ErrOrderRejected
+	Save(ctx context.Context, o Order) error
+	// SaveMany - сохранение пачки заказов, ошибка каждого заказа (как у Save) - по тому же индексу.
+	// Повтор OrderUID внутри пачки обрабатывается так же, как повтор уже сохраненного заказа
+	SaveMany(ctx context.Context, orders []Order) []error
+	// Get - чтение заказа со всеми связанными записями, если его нет - ErrOrderNotFound
+	Get(ctx context.Context, uid string) (Order, error)
+	// GetMany - чтение нескольких заказов за одно обращение, отсутствующих заказов в результате нет.
+	// Испорченные заказы пропускаются: остальные возвращаются вместе с ошибкой, оборачивающей ErrOrderCorrupt
+	GetMany(ctx context.Context, uids []string) (map[string]Order, error)
+	// List - до limit OrderUID от новых заказов к старым (заказы без даты создания - последними),
+	// начиная после курсора cursor, "" - с начала. Возвращает курсор следующей страницы, "" - страниц больше нет
+	List(ctx context.Context, cursor string, limit int) (uids []string, next string, err error)
+	// Delete - удаление заказа со всеми связанными записями, если его нет - ErrOrderNotFound
+	Delete(ctx context.Context, uid string) error
+	// Exists - есть ли заказ в хранилище
+	Exists(ctx context.Context, uid string) (bool, error)
+}
+
+// orderHash - хэш содержимого заказа, одинаковый для одинаковых заказов
+func orderHash(o Order) []byte {
+	// Order состоит только из строк, чисел и времени, поэтому сериализуется без ошибок
+	data, _ := json.Marshal(o)
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
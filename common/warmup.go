@@ -0,0 +1,223 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WarmupConfig - параметры прогрева кэша заказами из БД
+type WarmupConfig struct {
+	// Количество OrderUID, читаемых из БД за один запрос
+	PageSize int
+	// Количество заказов, загружаемых из БД одним запросом
+	BatchSize int
+	// Количество одновременно загружаемых пачек заказов
+	Workers int
+	// Максимальное количество заказов, 0 - по лимиту кэша MaxEntries, а если его нет - все
+	Limit int
+}
+
+// WarmupProgress - состояние прогрева кэша
+type WarmupProgress struct {
+	Running bool      `json:"running"`
+	Started time.Time `json:"started"`
+	// Длительность прогрева, для идущего - от начала до текущего момента
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Прочитано OrderUID из БД
+	Scanned int `json:"scanned"`
+	// Загружено в кэш
+	Loaded int `json:"loaded"`
+	// Пропущено, потому что заказ уже был в кэше
+	Skipped int `json:"skipped"`
+	// Не удалось загрузить
+	Failed int `json:"failed"`
+	// Ошибка, прервавшая прогрев
+	Error string `json:"error,omitempty"`
+}
+
+// localSetter - кэш, умеющий записывать заказы без оповещения других реплик (InvalidatingOrderCache)
+type localSetter interface {
+	SetLocal(uid string, o Order, duration time.Duration) error
+}
+
+// warmupState - текущее состояние прогрева, общее для его рабочих горутин
+type warmupState struct {
+	sync.Mutex
+	progress WarmupProgress
+	finished time.Time
+}
+
+// UploadCache - прогрев кэша заказами из БД, от новых к старым.
+// OrderUID читаются страницами по cfg.PageSize, сами заказы загружают пачками по cfg.BatchSize cfg.Workers горутин.
+// Заказы, которые уже есть в кэше (например, загружены из снимка), повторно из БД не читаются,
+// а заказы, которые не удалось загрузить, пропускаются. Каждое обращение к хранилищу ограничено DBTimeout.
+// Прогрев прерывается отменой ctx или ошибкой чтения страницы
+func (a *All) UploadCache(ctx context.Context, cfg WarmupConfig) error {
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = 500
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	limit := cfg.Limit
+	if limit == 0 {
+		limit = a.Cch.MaxEntries()
+	}
+
+	a.warmup.Lock()
+	a.warmup.progress = WarmupProgress{Running: true, Started: time.Now()}
+	a.warmup.Unlock()
+
+	batches := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uids := range batches {
+				a.warmBatch(ctx, uids)
+			}
+		}()
+	}
+
+	err := a.warmPages(ctx, cfg.PageSize, cfg.BatchSize, limit, batches)
+	close(batches)
+	wg.Wait()
+
+	a.warmup.Lock()
+	a.warmup.progress.Running = false
+	a.warmup.finished = time.Now()
+	if err != nil {
+		a.warmup.progress.Error = err.Error()
+	}
+	a.warmup.Unlock()
+
+	p := a.WarmupProgress()
+	fmt.Println(time.Now(), "warmup finished in", p.Elapsed, "loaded:", p.Loaded, "skipped:", p.Skipped, "failed:", p.Failed)
+	return err
+}
+
+// warmPages - постраничное чтение OrderUID из хранилища и передача их рабочим горутинам пачками через batches
+func (a *All) warmPages(ctx context.Context, pageSize, batchSize, limit int, batches chan<- []string) error {
+	cursor := ""
+	for scanned := 0; limit <= 0 || scanned < limit; {
+		size := pageSize
+		if limit > 0 && limit-scanned < size {
+			size = limit - scanned
+		}
+		pageCtx, cancel := withTimeout(ctx, a.DBTimeout)
+		page, next, err := a.Store.List(pageCtx, cursor, size)
+		cancel()
+		if err != nil {
+			return err
+		}
+		for len(page) > 0 {
+			n := min(batchSize, len(page))
+			select {
+			case batches <- page[:n]:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			scanned += n
+			page = page[n:]
+		}
+
+		a.warmup.Lock()
+		a.warmup.progress.Scanned = scanned
+		p := a.warmup.progress
+		a.warmup.Unlock()
+		fmt.Println(time.Now(), "warmup: scanned", p.Scanned, "loaded", p.Loaded, "skipped", p.Skipped, "failed", p.Failed)
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+	return nil
+}
+
+// warmBatch - загрузка пачки заказов в кэш одним запросом к хранилищу. Заказы, которые уже есть в кэше,
+// пропускаются, ошибки учитываются в прогрессе и не прерывают прогрев
+func (a *All) warmBatch(ctx context.Context, uids []string) {
+	missing := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		// Проверка наличия не должна считаться обращением: иначе прогрев искажает статистику
+		// попаданий и порядок вытеснения LRU/LFU и продлевает скользящее время жизни
+		if _, found := a.Cch.Peek(uid); !found {
+			missing = append(missing, uid)
+		}
+	}
+	skipped, loaded, failed := len(uids)-len(missing), 0, 0
+	// Заказы из БД не изменились, поэтому другие реплики об их записи не оповещаются
+	set := a.Cch.Set
+	if c, ok := a.Cch.(localSetter); ok {
+		set = c.SetLocal
+	}
+
+	batchCtx, cancel := withTimeout(ctx, a.DBTimeout)
+	orders, err := a.Store.GetMany(batchCtx, missing)
+	cancel()
+	if err != nil {
+		fmt.Println(time.Now(), "warmup batch failed:", err)
+		// Испорченные заказы пропускаются, остальные заказы пачки прочитаны
+		if !errors.Is(err, ErrOrderCorrupt) {
+			failed, missing = len(missing), nil
+		}
+	}
+	for _, uid := range missing {
+		o, ok := orders[uid]
+		if !ok {
+			// Заказ удален после чтения страницы или испорчен
+			failed++
+			continue
+		}
+		if err := set(uid, o, 0); err != nil {
+			fmt.Println(time.Now(), uid, "warmup failed:", err)
+			failed++
+			continue
+		}
+		loaded++
+	}
+
+	a.warmup.Lock()
+	a.warmup.progress.Skipped += skipped
+	a.warmup.progress.Loaded += loaded
+	a.warmup.progress.Failed += failed
+	a.warmup.Unlock()
+}
+
+// WarmupProgress - текущее состояние прогрева кэша
+func (a *All) WarmupProgress() WarmupProgress {
+	a.warmup.Lock()
+	defer a.warmup.Unlock()
+
+	p := a.warmup.progress
+	switch {
+	case p.Running:
+		p.Elapsed = time.Since(p.Started)
+	case !p.Started.IsZero():
+		p.Elapsed = a.warmup.finished.Sub(p.Started)
+	}
+	return p
+}
+
+// WarmupHandler - обработчик http-запросов состояния прогрева кэша, отдает WarmupProgress в формате JSON
+func (a *All) WarmupHandler(Writer http.ResponseWriter, Request *http.Request) {
+	if Request.Method != "GET" {
+		http.Error(Writer, "Invalid request method", 405)
+		return
+	}
+	Writer.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(Writer).Encode(a.WarmupProgress())
+	if err != nil {
+		http.Error(Writer, err.Error(), 500)
+	}
+}
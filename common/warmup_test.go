@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestUploadCacheSkipsCachedWithoutCounting(t *testing.T) {
+	a := newTestAll(t)
+	store := a.Store
+
+	var orders []Order
+	for i := 0; i < 5; i++ {
+		o := *NewOrderGen()
+		if err := store.Save(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+		orders = append(orders, o)
+	}
+	// Два заказа уже в кэше, например из снимка
+	for _, o := range orders[:2] {
+		if err := a.Cch.Set(o.OrderUID, o, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.UploadCache(context.Background(), WarmupConfig{PageSize: 2, BatchSize: 2, Workers: 2}); err != nil {
+		t.Fatalf("UploadCache: %v", err)
+	}
+
+	p := a.WarmupProgress()
+	if p.Loaded != 3 || p.Skipped != 2 || p.Failed != 0 {
+		t.Fatalf("progress loaded/skipped/failed = %d/%d/%d, want 3/2/0", p.Loaded, p.Skipped, p.Failed)
+	}
+	if n := a.Cch.Len(); n != len(orders) {
+		t.Fatalf("cache Len = %d, want %d", n, len(orders))
+	}
+	// Проверка наличия при прогреве не считается попаданием или промахом
+	if stats := a.Cch.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Stats hits/misses = %d/%d after warmup, want 0/0", stats.Hits, stats.Misses)
+	}
+}
+
+// corruptOrderStore - хранилище, в котором заказ corrupt испорчен и не читается
+type corruptOrderStore struct {
+	*MemoryOrderStore
+	corrupt string
+}
+
+func (s corruptOrderStore) GetMany(ctx context.Context, uids []string) (map[string]Order, error) {
+	orders, err := s.MemoryOrderStore.GetMany(ctx, uids)
+	if _, ok := orders[s.corrupt]; ok {
+		delete(orders, s.corrupt)
+		err = fmt.Errorf("%w: order %s", ErrOrderCorrupt, s.corrupt)
+	}
+	return orders, err
+}
+
+func TestUploadCacheSkipsCorrupt(t *testing.T) {
+	a := newTestAll(t)
+	store := NewMemoryOrderStore()
+	var uids []string
+	for i := 0; i < 4; i++ {
+		o := *NewOrderGen()
+		if err := store.Save(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, o.OrderUID)
+	}
+	a.Store = corruptOrderStore{MemoryOrderStore: store, corrupt: uids[0]}
+
+	// Вся пачка в одном запросе: испорченный заказ не должен мешать остальным
+	if err := a.UploadCache(context.Background(), WarmupConfig{PageSize: 4, BatchSize: 4, Workers: 1}); err != nil {
+		t.Fatalf("UploadCache: %v", err)
+	}
+
+	p := a.WarmupProgress()
+	if p.Loaded != 3 || p.Failed != 1 {
+		t.Fatalf("progress loaded/failed = %d/%d, want 3/1", p.Loaded, p.Failed)
+	}
+	if _, found := a.Cch.Peek(uids[0]); found {
+		t.Fatal("corrupt order cached")
+	}
+}
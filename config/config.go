@@ -0,0 +1,211 @@
+// Package config - настройки клиента и паблишера. Значения берутся по возрастанию приоритета:
+// значения по умолчанию, YAML-файл, переменные окружения с префиксом L0_ и флаги командной строки
+package config
+
+import (
+	"GoProjectL0/common"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EnvPrefix - префикс переменных окружения с настройками, например L0_DB_PASSWORD
+const EnvPrefix = "L0_"
+
+// Config - все настройки. Теги: yaml - ключ в файле, env - переменная окружения без префикса,
+// flag - флаг командной строки, usage - его описание
+type Config struct {
+	DB        DBConfig        `yaml:"db"`
+	NATS      NATSConfig      `yaml:"nats"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Ingest    IngestConfig    `yaml:"ingest"`
+	Warmup    WarmupConfig    `yaml:"warmup"`
+	Publisher PublisherConfig `yaml:"publisher"`
+}
+
+// DBConfig - подключение к Postgres
+type DBConfig struct {
+	User     string `yaml:"user" env:"DB_USER" flag:"db-user" usage:"Postgres user"`
+	Password string `yaml:"password" env:"DB_PASSWORD" flag:"db-password" usage:"Postgres password"`
+	Host     string `yaml:"host" env:"DB_HOST" flag:"db-host" usage:"Postgres host"`
+	Port     int    `yaml:"port" env:"DB_PORT" flag:"db-port" usage:"Postgres port"`
+	Name     string `yaml:"name" env:"DB_NAME" flag:"db-name" usage:"Postgres database"`
+	// Ограничение времени подключения и одного чтения заказов из БД
+	Timeout time.Duration `yaml:"timeout" env:"DB_TIMEOUT" flag:"db-timeout" usage:"max duration of connecting to DB and of a single order read"`
+	// Применять ли новые миграции схемы при старте клиента
+	Migrate bool `yaml:"migrate" env:"DB_MIGRATE" flag:"migrate" usage:"apply pending schema migrations at startup"`
+	// Ограничение времени применения миграций при старте, включая ожидание блокировки миграций другой реплики.
+	// Миграции больших таблиц могут идти долго, поэтому по умолчанию время не ограничено
+	MigrateTimeout time.Duration `yaml:"migrate_timeout" env:"DB_MIGRATE_TIMEOUT" flag:"migrate-timeout" usage:"max duration of applying schema migrations at startup, 0 - unlimited"`
+}
+
+// NATSConfig - подключение к nats-streaming
+type NATSConfig struct {
+	URL      string `yaml:"url" env:"NATS_URL" flag:"nats-url" usage:"NATS server URL"`
+	Cluster  string `yaml:"cluster" env:"NATS_CLUSTER" flag:"nats-cluster" usage:"NATS Streaming cluster ID"`
+	ClientID string `yaml:"client_id" env:"NATS_CLIENT_ID" flag:"nats-client-id" usage:"NATS Streaming client ID of the client"`
+	// Канал, в который паблишер отправляет заказы, а клиент их читает
+	Subject string        `yaml:"subject" env:"NATS_SUBJECT" flag:"nats-subject" usage:"NATS Streaming channel with orders"`
+	AckWait time.Duration `yaml:"ack_wait" env:"NATS_ACK_WAIT" flag:"nats-ack-wait" usage:"time before an unacknowledged order message is redelivered"`
+	// Имя долговременной подписки: сервер помнит, какие сообщения клиент подтвердил,
+	// и после перезапуска доставляет пропущенные, а не только новые
+	DurableName string `yaml:"durable_name" env:"NATS_DURABLE_NAME" flag:"nats-durable-name" usage:"NATS Streaming durable subscription name"`
+	// Тема оповещений об изменении заказов между клиентами с кэшем в памяти
+	InvalidationSubject string `yaml:"invalidation_subject" env:"NATS_INVALIDATION_SUBJECT" flag:"invalidation-subject" usage:"NATS subject for cache invalidations between -cache=memory replicas"`
+}
+
+// HTTPConfig - HTTP-сервер клиента
+type HTTPConfig struct {
+	Addr string `yaml:"addr" env:"HTTP_ADDR" flag:"http-addr" usage:"HTTP listen address"`
+	// Сколько при завершении ждать выполняющихся запросов, прежде чем прервать их
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" flag:"http-shutdown-timeout" usage:"how long to wait for active requests on shutdown"`
+}
+
+// CacheConfig - кэш заказов клиента
+type CacheConfig struct {
+	// memory - в памяти процесса, redis - общий на сервере Redis
+	Backend       string `yaml:"backend" env:"CACHE" flag:"cache" usage:"cache backend: memory or redis"`
+	RedisAddr     string `yaml:"redis_addr" env:"REDIS_ADDR" flag:"redis-addr" usage:"Redis address for -cache=redis"`
+	RedisPassword string `yaml:"redis_password" env:"REDIS_PASSWORD" flag:"redis-password" usage:"Redis password for -cache=redis, empty - no AUTH"`
+	RedisDB       int    `yaml:"redis_db" env:"REDIS_DB" flag:"redis-db" usage:"Redis database number for -cache=redis"`
+	// Префикс ключей заказов в Redis, позволяет делить один сервер между окружениями
+	RedisPrefix       string        `yaml:"redis_prefix" env:"REDIS_PREFIX" flag:"redis-prefix" usage:"prefix of order keys in Redis for -cache=redis"`
+	DefaultExpiration time.Duration `yaml:"default_expiration" env:"CACHE_TTL" flag:"cache-ttl" usage:"how long an order stays in cache"`
+	CleanupInterval   time.Duration `yaml:"cleanup_interval" env:"CACHE_CLEANUP" flag:"cache-cleanup" usage:"interval of expired orders cleanup for -cache=memory"`
+	// absolute - заказ истекает через default_expiration после записи, sliding - после последнего чтения
+	ExpirationMode string        `yaml:"expiration_mode" env:"CACHE_EXPIRATION_MODE" flag:"cache-expiration-mode" usage:"cache expiration mode: absolute or sliding"`
+	RefreshAhead   time.Duration `yaml:"refresh_ahead" env:"CACHE_REFRESH_AHEAD" flag:"cache-refresh-ahead" usage:"reload frequently read orders this long before they expire, 0 - never"`
+	MaxEntries     int           `yaml:"max_entries" env:"CACHE_MAX_ENTRIES" flag:"cache-max-entries" usage:"max orders in cache for -cache=memory, 0 - unlimited"`
+	MaxBytes       int64         `yaml:"max_bytes" env:"CACHE_MAX_BYTES" flag:"cache-max-bytes" usage:"max cache size in bytes for -cache=memory, 0 - unlimited"`
+	Shards         int           `yaml:"shards" env:"CACHE_SHARDS" flag:"cache-shards" usage:"number of cache shards for -cache=memory"`
+	// Политика вытеснения при достижении лимитов: ttl, lru или lfu
+	Policy string `yaml:"policy" env:"CACHE_POLICY" flag:"cache-policy" usage:"eviction policy for -cache=memory: ttl, lru or lfu"`
+	// Файл снимка кэша в памяти, пустая строка - снимок не сохраняется
+	Snapshot string `yaml:"snapshot" env:"CACHE_SNAPSHOT" flag:"cache-snapshot" usage:"cache snapshot file for -cache=memory, empty - no snapshot"`
+}
+
+// IngestConfig - запись заказов из сообщений в БД
+type IngestConfig struct {
+	BatchSize  int           `yaml:"batch_size" env:"BATCH_SIZE" flag:"batch-size" usage:"max orders written to DB in one batch, 0 - write every order separately"`
+	BatchDelay time.Duration `yaml:"batch_delay" env:"BATCH_DELAY" flag:"batch-delay" usage:"max time an order waits for its batch to fill"`
+	// Ограничение времени сохранения заказа (или пачки заказов), должно быть меньше nats.ack_wait
+	MessageTimeout time.Duration `yaml:"message_timeout" env:"MESSAGE_TIMEOUT" flag:"message-timeout" usage:"max duration of saving an order or a batch of orders"`
+}
+
+// WarmupConfig - прогрев кэша заказами из БД при старте клиента
+type WarmupConfig struct {
+	PageSize int `yaml:"page_size" env:"WARMUP_PAGE_SIZE" flag:"warmup-page-size" usage:"order IDs read from DB per page during cache warmup"`
+	// Количество заказов, загружаемых из БД одним запросом, не больше page_size
+	BatchSize int `yaml:"batch_size" env:"WARMUP_BATCH_SIZE" flag:"warmup-batch-size" usage:"orders loaded from DB per query during cache warmup"`
+	Workers   int `yaml:"workers" env:"WARMUP_WORKERS" flag:"warmup-workers" usage:"concurrent batch loads during cache warmup"`
+}
+
+// PublisherConfig - генерация заказов паблишером
+type PublisherConfig struct {
+	ClientID string        `yaml:"client_id" env:"PUBLISHER_CLIENT_ID" flag:"publisher-client-id" usage:"NATS Streaming client ID of the publisher"`
+	Interval time.Duration `yaml:"interval" env:"PUBLISHER_INTERVAL" flag:"publisher-interval" usage:"pause between published orders"`
+	// После каждых PauseEvery заказов паблишер молчит Pause, 0 - без долгих пауз
+	PauseEvery int           `yaml:"pause_every" env:"PUBLISHER_PAUSE_EVERY" flag:"publisher-pause-every" usage:"make a long pause after every N orders, 0 - never"`
+	Pause      time.Duration `yaml:"pause" env:"PUBLISHER_PAUSE" flag:"publisher-pause" usage:"length of the long pause"`
+}
+
+// Default - настройки по умолчанию, совпадающие с docker-compose.yml
+func Default() Config {
+	return Config{
+		DB: DBConfig{User: "postgres", Password: "1234", Host: "localhost", Port: 5432, Name: "mydb", Timeout: 5 * time.Second, Migrate: true},
+		NATS: NATSConfig{
+			URL:                 "0.0.0.0:4222",
+			Cluster:             "test-cluster",
+			ClientID:            "client-123",
+			Subject:             "foo",
+			AckWait:             30 * time.Second,
+			DurableName:         "orders-client",
+			InvalidationSubject: "orders.invalidate",
+		},
+		HTTP: HTTPConfig{Addr: ":3000", ShutdownTimeout: 10 * time.Second},
+		Cache: CacheConfig{
+			Backend:           "memory",
+			RedisAddr:         "localhost:6379",
+			RedisPrefix:       "order:",
+			DefaultExpiration: 15 * time.Minute,
+			CleanupInterval:   3 * time.Minute,
+			ExpirationMode:    common.ExpireAbsolute.String(),
+			RefreshAhead:      time.Minute,
+			MaxEntries:        10000,
+			MaxBytes:          128 << 20,
+			Shards:            32,
+			Policy:            common.PolicyLRU.String(),
+			Snapshot:          "cache.snapshot",
+		},
+		Ingest:    IngestConfig{BatchSize: 100, BatchDelay: 20 * time.Millisecond, MessageTimeout: 20 * time.Second},
+		Warmup:    WarmupConfig{PageSize: 500, BatchSize: 100, Workers: 4},
+		Publisher: PublisherConfig{ClientID: "client-publisher", Interval: 30 * time.Second, PauseEvery: 10, Pause: 10 * time.Minute},
+	}
+}
+
+// Validate - проверка настроек, возвращает все найденные ошибки сразу
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.DB.User != "", "db.user is empty")
+	check(c.DB.Host != "", "db.host is empty")
+	check(c.DB.Port > 0 && c.DB.Port < 65536, "db.port %d is out of range", c.DB.Port)
+	check(c.DB.Name != "", "db.name is empty")
+	check(c.DB.Timeout > 0, "db.timeout must be positive")
+	check(c.DB.MigrateTimeout >= 0, "db.migrate_timeout is negative")
+
+	check(c.NATS.URL != "", "nats.url is empty")
+	check(c.NATS.Cluster != "", "nats.cluster is empty")
+	check(c.NATS.ClientID != "", "nats.client_id is empty")
+	check(c.NATS.Subject != "", "nats.subject is empty")
+	check(c.NATS.DurableName != "", "nats.durable_name is empty")
+	check(c.NATS.AckWait >= time.Second, "nats.ack_wait %v is less than 1s", c.NATS.AckWait)
+	check(c.NATS.InvalidationSubject != "", "nats.invalidation_subject is empty")
+
+	check(c.HTTP.Addr != "", "http.addr is empty")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+
+	check(c.Cache.Backend == "memory" || c.Cache.Backend == "redis", "cache.backend %q is not memory or redis", c.Cache.Backend)
+	check(c.Cache.Backend != "redis" || c.Cache.RedisAddr != "", "cache.redis_addr is empty")
+	check(c.Cache.RedisDB >= 0, "cache.redis_db is negative")
+	check(c.Cache.Backend != "redis" || c.Cache.RedisPrefix != "", "cache.redis_prefix is empty")
+	check(c.Cache.DefaultExpiration > 0, "cache.default_expiration must be positive")
+	check(c.Cache.CleanupInterval > 0, "cache.cleanup_interval must be positive")
+	_, err := common.ParseExpirationMode(c.Cache.ExpirationMode)
+	check(err == nil, "cache.expiration_mode %q is not absolute or sliding", c.Cache.ExpirationMode)
+	check(c.Cache.RefreshAhead >= 0 && c.Cache.RefreshAhead < c.Cache.DefaultExpiration,
+		"cache.refresh_ahead %v must be non-negative and less than cache.default_expiration", c.Cache.RefreshAhead)
+	check(c.Cache.MaxEntries >= 0, "cache.max_entries is negative")
+	check(c.Cache.MaxBytes >= 0, "cache.max_bytes is negative")
+	check(c.Cache.Shards > 0, "cache.shards must be positive")
+	_, err = common.ParseEvictionPolicy(c.Cache.Policy)
+	check(err == nil, "cache.policy %q is not ttl, lru or lfu", c.Cache.Policy)
+
+	check(c.Ingest.BatchSize >= 0, "ingest.batch_size is negative")
+	check(c.Ingest.BatchSize == 0 || c.Ingest.BatchDelay > 0, "ingest.batch_delay must be positive")
+	// Иначе сообщение доставляется повторно, пока его первая доставка еще сохраняется
+	check(c.Ingest.MessageTimeout > 0 && c.Ingest.MessageTimeout+c.Ingest.BatchDelay < c.NATS.AckWait,
+		"ingest.message_timeout %v must be positive and, with ingest.batch_delay, less than nats.ack_wait", c.Ingest.MessageTimeout)
+
+	check(c.Warmup.PageSize > 0, "warmup.page_size must be positive")
+	check(c.Warmup.BatchSize > 0 && c.Warmup.BatchSize <= c.Warmup.PageSize,
+		"warmup.batch_size %d must be positive and not greater than warmup.page_size", c.Warmup.BatchSize)
+	check(c.Warmup.Workers > 0, "warmup.workers must be positive")
+
+	check(c.Publisher.ClientID != "", "publisher.client_id is empty")
+	check(c.Publisher.ClientID != c.NATS.ClientID, "publisher.client_id and nats.client_id are both %q", c.NATS.ClientID)
+	check(c.Publisher.Interval >= 0, "publisher.interval is negative")
+	check(c.Publisher.PauseEvery >= 0, "publisher.pause_every is negative")
+	check(c.Publisher.Pause >= 0, "publisher.pause is negative")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Bad config: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Default().Validate() = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{name: "unlimited migrations", change: func(c *Config) { c.DB.MigrateTimeout = 0 }},
+		{name: "negative migrate timeout", change: func(c *Config) { c.DB.MigrateTimeout = -time.Second }, wantErr: "db.migrate_timeout"},
+		{name: "eviction policy", change: func(c *Config) { c.Cache.Policy = "lfu" }},
+		{name: "unknown eviction policy", change: func(c *Config) { c.Cache.Policy = "mru" }, wantErr: "cache.policy"},
+		{name: "eviction policy is case sensitive", change: func(c *Config) { c.Cache.Policy = "LRU" }, wantErr: "cache.policy"},
+		{name: "sliding expiration", change: func(c *Config) { c.Cache.ExpirationMode = "sliding" }},
+		{name: "unknown expiration mode", change: func(c *Config) { c.Cache.ExpirationMode = "fixed" }, wantErr: "cache.expiration_mode"},
+		{name: "negative redis db", change: func(c *Config) { c.Cache.RedisDB = -1 }, wantErr: "cache.redis_db"},
+		{name: "empty redis prefix with redis", change: func(c *Config) {
+			c.Cache.Backend = "redis"
+			c.Cache.RedisPrefix = ""
+		}, wantErr: "cache.redis_prefix"},
+		{name: "empty redis prefix with memory", change: func(c *Config) { c.Cache.RedisPrefix = "" }},
+		{name: "empty durable name", change: func(c *Config) { c.NATS.DurableName = "" }, wantErr: "nats.durable_name"},
+		{name: "zero warmup batch", change: func(c *Config) { c.Warmup.BatchSize = 0 }, wantErr: "warmup.batch_size"},
+		{name: "warmup batch larger than page", change: func(c *Config) { c.Warmup.BatchSize = c.Warmup.PageSize + 1 }, wantErr: "warmup.batch_size"},
+		{name: "warmup batch equal to page", change: func(c *Config) { c.Warmup.BatchSize = c.Warmup.PageSize }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.change(&c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeConfig - YAML-файл настроек во временном каталоге теста
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+nats:
+  durable_name: from-file
+cache:
+  policy: lfu
+  expiration_mode: sliding
+  redis_password: secret
+  redis_db: 2
+  redis_prefix: "file:"
+warmup:
+  batch_size: 50
+`)
+	t.Setenv(EnvPrefix+"CACHE_POLICY", "ttl")
+	t.Setenv(EnvPrefix+"REDIS_DB", "3")
+
+	cfg, args, err := Load("client", []string{"-config", path, "-cache-policy", "lru", "-warmup-batch-size=20", "migrate"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checks := []struct {
+		name string
+		got  any
+		want any
+	}{
+		// Флаг важнее переменной окружения, а она - файла
+		{"cache.policy", cfg.Cache.Policy, "lru"},
+		{"cache.redis_db", cfg.Cache.RedisDB, 3},
+		{"warmup.batch_size", cfg.Warmup.BatchSize, 20},
+		{"cache.expiration_mode", cfg.Cache.ExpirationMode, "sliding"},
+		{"cache.redis_password", cfg.Cache.RedisPassword, "secret"},
+		{"cache.redis_prefix", cfg.Cache.RedisPrefix, "file:"},
+		{"nats.durable_name", cfg.NATS.DurableName, "from-file"},
+		// Не заданное нигде остается по умолчанию
+		{"warmup.page_size", cfg.Warmup.PageSize, Default().Warmup.PageSize},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if len(args) != 1 || args[0] != "migrate" {
+		t.Errorf("args = %v, want [migrate]", args)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{name: "unknown file key", file: "cache:\n  polcy: lru\n", wantErr: "polcy"},
+		{name: "bad env number", env: map[string]string{"REDIS_DB": "two"}, wantErr: EnvPrefix + "REDIS_DB"},
+		{name: "bad flag duration", args: []string{"-cache-ttl", "soon"}, wantErr: "-cache-ttl"},
+		{name: "invalid value", args: []string{"-cache-expiration-mode", "fixed"}, wantErr: "cache.expiration_mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+			for k, v := range tt.env {
+				t.Setenv(EnvPrefix+k, v)
+			}
+			_, _, err := Load("client", args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() = %v, want error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
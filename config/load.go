@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// field - настройка, которую можно задать переменной окружения и флагом
+type field struct {
+	env   string
+	flag  string
+	usage string
+	value reflect.Value
+}
+
+// fields - настройки cfg с тегами env и flag, value указывает прямо в cfg
+func fields(cfg *Config) []field {
+	var result []field
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			tag := section.Type().Field(j).Tag
+			result = append(result, field{env: tag.Get("env"), flag: tag.Get("flag"), usage: tag.Get("usage"), value: section.Field(j)})
+		}
+	}
+	return result
+}
+
+// set - присвоение настройке значения из строки
+func (f field) set(s string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(s)
+	case bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(v)
+	case time.Duration:
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(v))
+	case int, int64:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		if f.value.OverflowInt(v) {
+			return fmt.Errorf("%s is out of range", s)
+		}
+		f.value.SetInt(v)
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+	return nil
+}
+
+// flagValue - значение флага, которое запоминается при разборе командной строки и применяется
+// к настройкам после файла и переменных окружения, чтобы у флагов был наивысший приоритет
+type flagValue struct {
+	def   string
+	value *string
+	bool  bool
+}
+
+// String - значение по умолчанию для справки по флагам
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.def
+}
+
+// Set - запоминание значения флага из командной строки
+func (v *flagValue) Set(s string) error {
+	*v.value = s
+	return nil
+}
+
+// IsBoolFlag - булевы флаги можно задавать без значения, например -migrate
+func (v *flagValue) IsBoolFlag() bool {
+	return v.bool
+}
+
+// Load - настройки программы name из аргументов командной строки args (без имени программы).
+// Путь к YAML-файлу задается флагом -config или переменной окружения L0_CONFIG, без него файл не читается.
+// Приоритет: флаги, переменные окружения, файл, значения по умолчанию. Возвращает настройки
+// и аргументы, оставшиеся после флагов (например, команду migrate)
+func Load(name string, args []string) (Config, []string, error) {
+	cfg := Default()
+	all := fields(&cfg)
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML config file")
+	values := make([]string, len(all))
+	byFlag := make(map[string]int, len(all))
+	for i, f := range all {
+		byFlag[f.flag] = i
+		_, isBool := f.value.Interface().(bool)
+		fs.Var(&flagValue{def: fmt.Sprint(f.value.Interface()), value: &values[i], bool: isBool}, f.flag, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *path != "" {
+		if err := loadFile(&cfg, *path); err != nil {
+			return cfg, nil, err
+		}
+	}
+	for _, f := range all {
+		s, ok := os.LookupEnv(EnvPrefix + f.env)
+		if !ok {
+			continue
+		}
+		if err := f.set(s); err != nil {
+			return cfg, nil, fmt.Errorf("Bad environment variable %s%s: %w", EnvPrefix, f.env, err)
+		}
+	}
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		i, ok := byFlag[fl.Name]
+		if !ok || err != nil {
+			return
+		}
+		if e := all[i].set(values[i]); e != nil {
+			err = fmt.Errorf("Bad flag -%s: %w", fl.Name, e)
+		}
+	})
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile - чтение настроек из YAML-файла поверх cfg. Неизвестные ключи считаются ошибкой,
+// чтобы опечатка в имени настройки не оставляла молча значение по умолчанию
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Reading config failed: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// Пустой файл - допустимый файл без настроек
+	if err = dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("Parsing config %s failed: %w", path, err)
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"GoProjectL0/common"
+	"GoProjectL0/config"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/nats-io/stan.go"
 	"math"
@@ -12,8 +15,18 @@ import (
 )
 
 func main() {
+	// читаем настройки: файл, переменные окружения L0_*, флаги
+	cfg, _, err := config.Load("publisher", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println(time.Now(), "Config err", err)
+		os.Exit(2)
+	}
+
 	// подключаемся к серверу сообщений
-	StreamConnection, err := stan.Connect("test-cluster", "client-publisher", stan.NatsURL("0.0.0.0:4222"))
+	StreamConnection, err := stan.Connect(cfg.NATS.Cluster, cfg.Publisher.ClientID, stan.NatsURL(cfg.NATS.URL))
 	if err != nil {
 		fmt.Println(time.Now(), "Connection err", err)
 		os.Exit(1)
@@ -28,7 +41,7 @@ func main() {
 			fmt.Println(time.Now(), "JSON err:", err)
 			continue
 		}
-		err = StreamConnection.Publish("foo", JsonOrder) // отправляем в канал
+		err = StreamConnection.Publish(cfg.NATS.Subject, JsonOrder) // отправляем в канал
 		if err != nil {
 			fmt.Println(time.Now(), "Publish err:", err)
 			continue
@@ -36,12 +49,11 @@ func main() {
 		// сообщение об индексе заказа и его уникальный номер, отсюда можно брать информацию, чтобы потом на сайте
 		// посмотреть успешно добавилось в базу данных и/или кэш или нет
 		fmt.Println(time.Now(), "Index =", i, "OrderUID =", GeneratedOrder.OrderUID)
-		// частота сообщений пока регулируется этим sleep'ом можно менять значения, но у меня тормознутый комп
-		// поэтому Я оставлю 30 секунд
-		time.Sleep(30 * time.Second)
-		if i%10 == 0 && i != 0 {
-			time.Sleep(10 * time.Minute)
-			// после отправления 10 записей паблишер замолкает на 10 минут, если с 0, то 11)
+		// частота сообщений регулируется настройкой publisher.interval, по умолчанию 30 секунд
+		time.Sleep(cfg.Publisher.Interval)
+		if cfg.Publisher.PauseEvery > 0 && i%cfg.Publisher.PauseEvery == 0 && i != 0 {
+			time.Sleep(cfg.Publisher.Pause)
+			// после отправления publisher.pause_every записей паблишер замолкает на publisher.pause, если с 0, то на одну больше)
 		}
 	}
 	// заглушка для завершения работы